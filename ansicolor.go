@@ -12,31 +12,155 @@ type outputMode int
 // DiscardNonColorEscSeq supports the divided color escape sequence.
 // But non-color escape sequence is not output.
 // Please use the OutputNonColorEscSeq If you want to output a non-color
-// escape sequences such as ncurses. However, it does not support the divided
-// color escape sequence.
+// escape sequences such as ncurses. It also supports the divided escape
+// sequence, which is output once it is complete.
 const (
 	_ outputMode = iota
 	DiscardNonColorEscSeq
 	OutputNonColorEscSeq
 )
 
+// The default limits of the escape sequences.
+const (
+	// DefaultMaxStringLength limits the payload of a string such as DCS,
+	// OSC or APC.
+	DefaultMaxStringLength = 64 * 1024
+	// DefaultMaxSequenceLength limits the length of another sequence.
+	DefaultMaxSequenceLength = 256
+	// DefaultMaxParams limits the number of parameters of a sequence.
+	DefaultMaxParams = 32
+)
+
+// Option configures a writer created by NewModeAnsiColorWriter.
+type Option func(*ansiColorWriter)
+
+// WithConsole makes the writer translate the escape sequences into calls
+// on c instead of the console of the platform. The default attributes are
+// taken from c when the writer is created. A nil c, or a c whose screen
+// buffer cannot be read such as a redirected handle, means no console, and
+// the escape sequences are handled as selected by WithFallback.
+func WithConsole(c Console) Option {
+	return func(cw *ansiColorWriter) {
+		cw.console = nil
+		cw.defaultAttr = nil
+		if c == nil {
+			return
+		}
+		if info, err := c.ScreenBufferInfo(); err == nil {
+			cw.console = c
+			cw.defaultAttr = convertTextAttr(info.Attributes)
+		}
+	}
+}
+
+// Fallback selects how a writer without a console handles the escape
+// sequences.
+type Fallback int
+
+const (
+	// FallbackPassThrough writes all text including the escape sequences
+	// to the underlying writer as is. It is the default.
+	FallbackPassThrough Fallback = iota
+	// FallbackStrip removes the escape sequences and writes the rest.
+	FallbackStrip
+)
+
+// WithFallback selects how the writer handles the escape sequences when
+// it has no console, such as when the underlying writer is a file, a pipe
+// or a buffer.
+func WithFallback(f Fallback) Option {
+	return func(cw *ansiColorWriter) {
+		cw.fallback = f
+	}
+}
+
+// OSCHandler receives the payload of an operating system command such as
+// "0;title" of ESC ] 0 ; title BEL, without the introducer and the
+// terminator. The payload is only valid until the handler returns.
+type OSCHandler func(payload []byte)
+
+// WithOSCHandler makes the writer pass every operating system command to h
+// instead of discarding it in DiscardNonColorEscSeq mode or writing it
+// in OutputNonColorEscSeq mode.
+func WithOSCHandler(h OSCHandler) Option {
+	return func(cw *ansiColorWriter) {
+		cw.oscHandler = h
+	}
+}
+
+// WithColorMetric selects the distance used to match a 256-color or a
+// 24-bit color to the nearest of the 16 console colors. The default is
+// RGBDistance.
+func WithColorMetric(m ColorMetric) Option {
+	return func(cw *ansiColorWriter) {
+		cw.quantizer.metric = m
+	}
+}
+
+// WithC1Controls makes the writer recognise the 8-bit C1 controls such as
+// 0x9B (CSI) and 0x9D (OSC) as well as their 7-bit ESC forms. It is for a
+// stream that is not UTF-8, such as Latin-1, as the bytes 0x80 to 0x9F
+// are always taken as the controls.
+func WithC1Controls() Option {
+	return func(cw *ansiColorWriter) {
+		cw.tokenizer.C1 = true
+	}
+}
+
+// WithMaxStringLength limits the payload of a string such as DCS, OSC or APC
+// to n bytes. A longer string is discarded up to its terminator in every
+// mode. Zero removes the limit.
+func WithMaxStringLength(n int) Option {
+	return func(cw *ansiColorWriter) {
+		cw.tokenizer.MaxStringLen = n
+	}
+}
+
+// WithSequenceLimits limits an escape sequence other than a string to
+// maxLength bytes and maxParams parameters, counting the colon separated
+// sub-parameters. A sequence over the limits is abandoned up to its final
+// byte without being kept in memory: it is output as is in
+// OutputNonColorEscSeq mode and discarded in DiscardNonColorEscSeq mode.
+// Zero removes a limit.
+func WithSequenceLimits(maxLength, maxParams int) Option {
+	return func(cw *ansiColorWriter) {
+		cw.tokenizer.MaxSequenceLen = maxLength
+		cw.tokenizer.MaxParams = maxParams
+	}
+}
+
 // NewAnsiColorWriter creates and initializes a new ansiColorWriter
 // using io.Writer w as its initial contents.
 // In the console of Windows, which change the foreground and background
-// colors of the text by the escape sequence.
+// colors of the text by the escape sequence. The console is that of w
+// when w is an *os.File or has an Fd() uintptr method, and w has no
+// console otherwise.
 // In the console of other systems, which writes to w all text.
+// The default colors restored by SGR 0, 39 and 49 are those that the
+// console of w had when the writer was created.
+//
+// The returned writer also implements Flush() error and io.Closer.
+// Flush outputs or discards an escape sequence left incomplete at the end
+// of the text, and Close restores the original colors of the console.
 func NewAnsiColorWriter(w io.Writer) io.Writer {
 	return NewModeAnsiColorWriter(w, DiscardNonColorEscSeq)
 }
 
 // NewModeAnsiColorWriter create and initializes a new ansiColorWriter
 // by specifying the outputMode.
-func NewModeAnsiColorWriter(w io.Writer, mode outputMode) io.Writer {
+func NewModeAnsiColorWriter(w io.Writer, mode outputMode, opts ...Option) io.Writer {
 	if _, ok := w.(*ansiColorWriter); !ok {
-		return &ansiColorWriter{
-			w:    w,
-			mode: mode,
+		cw := newAnsiColorWriter(w, mode)
+		cw.tokenizer.MaxStringLen = DefaultMaxStringLength
+		cw.tokenizer.MaxSequenceLen = DefaultMaxSequenceLength
+		cw.tokenizer.MaxParams = DefaultMaxParams
+		cw.renderings = defaultRenderings
+		cw.palette = DefaultPalette
+		cw.maxStyleStack = DefaultMaxStyleStack
+		for _, opt := range opts {
+			opt(cw)
 		}
+		return cw
 	}
 	return w
 }
@@ -24,4 +24,5 @@ import (
 func main() {
 	w := ansicolor.NewAnsiColorWriter(os.Stdout)
 	io.Copy(w, os.Stdin)
+	w.(io.Closer).Close()
 }
@@ -9,11 +9,11 @@ package ansicolor
 
 import "io"
 
-type ansiColorWriter struct {
-	w    io.Writer
-	mode outputMode
-}
-
-func (cw *ansiColorWriter) Write(p []byte) (int, error) {
-	return cw.w.Write(p)
+// newAnsiColorWriter returns a writer without a console, which writes all
+// text to w as is. Use WithConsole to translate the escape sequences.
+func newAnsiColorWriter(w io.Writer, mode outputMode) *ansiColorWriter {
+	return &ansiColorWriter{
+		w:    w,
+		mode: mode,
+	}
 }
@@ -19,7 +19,7 @@ import (
 
 func TestWritePlanText(t *testing.T) {
 	inner := bytes.NewBufferString("")
-	w := ansicolor.NewAnsiColorWriter(inner)
+	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithConsole(StdoutConsole))
 	expected := "plain text"
 	fmt.Fprintf(w, expected)
 	actual := inner.String()
@@ -30,7 +30,7 @@ func TestWritePlanText(t *testing.T) {
 
 func TestWriteParseText(t *testing.T) {
 	inner := bytes.NewBufferString("")
-	w := ansicolor.NewAnsiColorWriter(inner)
+	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithConsole(StdoutConsole))
 
 	inputTail := "\x1b[0mtail text"
 	expectedTail := "tail text"
@@ -85,7 +85,7 @@ type screenNotFoundError struct {
 
 func writeAnsiColor(expectedText, colorCode string) (actualText string, actualAttributes uint16, err error) {
 	inner := bytes.NewBufferString("")
-	w := ansicolor.NewAnsiColorWriter(inner)
+	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithConsole(StdoutConsole))
 	fmt.Fprintf(w, "\x1b[%sm%s", colorCode, expectedText)
 
 	actualText = inner.String()
@@ -238,7 +238,7 @@ func TestWriteAnsiColorText(t *testing.T) {
 
 func TestIgnoreUnknownSequences(t *testing.T) {
 	inner := bytes.NewBufferString("")
-	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq)
+	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(StdoutConsole))
 
 	inputText := "\x1b[=decpath mode"
 	expectedTail := inputText
@@ -250,7 +250,7 @@ func TestIgnoreUnknownSequences(t *testing.T) {
 	}
 
 	inputText = "\x1b[=tailing esc and bracket\x1b["
-	expectedTail = inputText
+	expectedTail = "\x1b[=tailing esc and bracket"
 	fmt.Fprintf(w, inputText)
 	actualTail = inner.String()
 	inner.Reset()
@@ -259,7 +259,7 @@ func TestIgnoreUnknownSequences(t *testing.T) {
 	}
 
 	inputText = "\x1b[?tailing esc\x1b"
-	expectedTail = inputText
+	expectedTail = "\x1b[\x1b[?tailing esc"
 	fmt.Fprintf(w, inputText)
 	actualTail = inner.String()
 	inner.Reset()
@@ -268,7 +268,7 @@ func TestIgnoreUnknownSequences(t *testing.T) {
 	}
 
 	inputText = "\x1b[1h;3punended color code invalid\x1b3"
-	expectedTail = inputText
+	expectedTail = "\x1b\x1b[1h;3punended color code invalid\x1b3"
 	fmt.Fprintf(w, inputText)
 	actualTail = inner.String()
 	inner.Reset()
@@ -276,3 +276,30 @@ func TestIgnoreUnknownSequences(t *testing.T) {
 		t.Errorf("Get %q, want %q", actualTail, expectedTail)
 	}
 }
+
+func TestWriteToWriterWithoutConsole(t *testing.T) {
+	ResetColor()
+	if GetConsoleScreenBufferInfo(uintptr(syscall.Stdout)) == nil {
+		t.Skip("stdout is not a console")
+	}
+
+	tests := []struct {
+		fallback ansicolor.Fallback
+		expected string
+	}{
+		{ansicolor.FallbackPassThrough, "\x1b[31mtext"},
+		{ansicolor.FallbackStrip, "text"},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithFallback(v.fallback))
+		fmt.Fprint(w, "\x1b[31mtext")
+
+		if actual := inner.String(); actual != v.expected {
+			t.Errorf("Get %q, want %q", actual, v.expected)
+		}
+		if actual := GetConsoleScreenBufferInfo(uintptr(syscall.Stdout)).WAttributes; actual != 0x0007 {
+			t.Errorf("Get 0x%04x, want 0x0007", actual)
+		}
+	}
+}
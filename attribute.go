@@ -0,0 +1,217 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+// Attribute is a text attribute of SGR. The console has no exact way to
+// show most of them, and how each attribute is shown is selected by
+// WithRendering.
+type Attribute int
+
+const (
+	Bold          Attribute = iota // SGR 1, off by 21 or 22
+	Faint                          // SGR 2, off by 22
+	Italic                         // SGR 3, off by 23
+	Blink                          // SGR 5, off by 25
+	RapidBlink                     // SGR 6, off by 25
+	Strikethrough                  // SGR 9, off by 29
+	Fraktur                        // SGR 20, off by 23
+	Framed                         // SGR 51, off by 54
+	Encircled                      // SGR 52, off by 54
+	Overline                       // SGR 53, off by 55
+	Underline                      // SGR 4, off by 24
+	Reverse                        // SGR 7, off by 27
+	Conceal                        // SGR 8, off by 28
+
+	attributeCount
+)
+
+// Rendering is a way of the console to show an Attribute.
+type Rendering int
+
+const (
+	// RenderNone does not show the attribute.
+	RenderNone Rendering = iota
+	// RenderIntensity turns on the foreground intensity.
+	RenderIntensity
+	// RenderNormal turns off the foreground intensity, including that of a
+	// bright color, and overrides RenderIntensity.
+	RenderNormal
+	// RenderUnderline turns on the underline.
+	RenderUnderline
+	// RenderBackgroundIntensity turns on the background intensity.
+	RenderBackgroundIntensity
+	// RenderReverse swaps the foreground and background colors.
+	RenderReverse
+	// RenderOverline draws a line above the text. The console shows it
+	// only with a DBCS code page.
+	RenderOverline
+	// RenderBox draws lines above and at both sides of every character.
+	// The console shows it only with a DBCS code page.
+	RenderBox
+	// RenderBrightBasicColors turns the basic foreground colors of SGR 30
+	// to 37 into the bright colors of 90 to 97.
+	RenderBrightBasicColors
+	// RenderConceal shows the foreground color as the background color.
+	RenderConceal
+)
+
+const (
+	gridHorizontal = uint16(0x0400)
+	gridLVertical  = uint16(0x0800)
+	gridRVertical  = uint16(0x1000)
+)
+
+// defaultRenderings shows bold and blink as the intensities like the
+// original translator, faint as the normal intensity, the frames as
+// the grid lines, underline, reverse and conceal as they are, and nothing
+// for the rest.
+var defaultRenderings = [attributeCount]Rendering{
+	Bold:      RenderIntensity,
+	Faint:     RenderNormal,
+	Blink:     RenderBackgroundIntensity,
+	Framed:    RenderBox,
+	Encircled: RenderBox,
+	Overline:  RenderOverline,
+	Underline: RenderUnderline,
+	Reverse:   RenderReverse,
+	Conceal:   RenderConceal,
+}
+
+// WithRendering selects how the console shows the attribute a. The
+// default is RenderIntensity for Bold, RenderNormal for Faint,
+// RenderBackgroundIntensity for Blink, RenderBox for Framed and Encircled,
+// RenderOverline for Overline, RenderUnderline for Underline,
+// RenderReverse for Reverse, RenderConceal for Conceal and RenderNone for
+// the others.
+func WithRendering(a Attribute, r Rendering) Option {
+	return func(cw *ansiColorWriter) {
+		if 0 <= a && a < attributeCount {
+			cw.renderings[a] = r
+		}
+	}
+}
+
+// BoldPolicy selects how the console shows bold, which it has no font for.
+type BoldPolicy int
+
+const (
+	// BoldAsIntensity shows bold as the foreground intensity, so that bold
+	// and the bright colors look the same. It is the default.
+	BoldAsIntensity BoldPolicy = iota
+	// BoldIgnored does not show bold.
+	BoldIgnored
+	// BoldBrightensBasicColors shows bold as the bright colors only for
+	// the basic colors of SGR 30 to 37, as many terminals do.
+	BoldBrightensBasicColors
+)
+
+// WithBoldPolicy selects how the console shows bold. It is the same as
+// WithRendering for Bold with RenderIntensity, RenderNone or
+// RenderBrightBasicColors.
+func WithBoldPolicy(p BoldPolicy) Option {
+	switch p {
+	case BoldIgnored:
+		return WithRendering(Bold, RenderNone)
+	case BoldBrightensBasicColors:
+		return WithRendering(Bold, RenderBrightBasicColors)
+	default:
+		return WithRendering(Bold, RenderIntensity)
+	}
+}
+
+// AttributeSet is a set of Attribute.
+type AttributeSet uint32
+
+// Has reports whether a is in s.
+func (s AttributeSet) Has(a Attribute) bool {
+	return s&(1<<a) != 0
+}
+
+// Set adds a to s if on is true and removes it otherwise.
+func (s *AttributeSet) Set(a Attribute, on bool) {
+	if on {
+		*s |= 1 << a
+	} else {
+		*s &^= 1 << a
+	}
+}
+
+// render returns the console attribute that shows the style of the writer
+// with the palette and the renderings.
+func (cw *ansiColorWriter) render() uint16 {
+	s, base := &cw.style, &cw.base
+	fg := cw.consoleColor(s.Foreground, false)
+	if s.Foreground.Kind == ColorDefault && base.hasForeground {
+		fg = base.foreground
+	}
+	fg |= base.foregroundIntensity
+	bg := cw.consoleColor(s.Background, true)
+	if s.Background.Kind == ColorDefault && base.hasBackground {
+		bg = base.background
+	}
+	bg |= base.backgroundIntensity
+
+	other := base.other
+	normal, reverse, conceal := false, false, false
+	for a, r := range cw.renderings {
+		if !s.Attributes.Has(Attribute(a)) {
+			continue
+		}
+		switch r {
+		case RenderIntensity:
+			fg |= consoleIntensityMask
+		case RenderNormal:
+			normal = true
+		case RenderUnderline:
+			other |= underscore
+		case RenderBackgroundIntensity:
+			bg |= consoleIntensityMask
+		case RenderReverse:
+			reverse = !reverse
+		case RenderOverline:
+			other |= gridHorizontal
+		case RenderBox:
+			other |= gridHorizontal | gridLVertical | gridRVertical
+		case RenderBrightBasicColors:
+			if s.Foreground.Kind == ColorBasic && s.Foreground.Index < 8 {
+				fg |= consoleIntensityMask
+			}
+		case RenderConceal:
+			conceal = true
+		}
+	}
+	if normal {
+		fg &^= consoleIntensityMask
+	}
+	if reverse {
+		fg, bg = bg, fg
+	}
+	if conceal {
+		fg = bg
+	}
+	return fg | bg<<4 | other
+}
+
+// consoleColor returns the 4-bit console color of c.
+func (cw *ansiColorWriter) consoleColor(c Color, isBackground bool) uint16 {
+	switch c.Kind {
+	case ColorBasic, ColorIndexed:
+		if c.Kind == ColorIndexed && c.Index >= 16 {
+			return cw.quantizer.xterm256(int(c.Index))
+		}
+		// the first 16 of the 256 colors are the ANSI colors
+		if isBackground {
+			return cw.palette.Background[c.Index&0xf] & 0xf
+		}
+		return cw.palette.Foreground[c.Index&0xf] & 0xf
+	case ColorRGB:
+		return cw.quantizer.nearest(rgb{int(c.R), int(c.G), int(c.B)})
+	}
+	fg, bg := cw.palette.defaultColors(cw.defaultAttr)
+	if isBackground {
+		return bg
+	}
+	return fg
+}
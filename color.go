@@ -0,0 +1,208 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+import (
+	"math"
+
+	"github.com/shiena/ansicolor/parse"
+)
+
+const (
+	extendedColorRGB     = 2
+	extendedColor256     = 5
+	consoleColorCount    = 16
+	consoleIntensityMask = 0x8
+)
+
+type rgb struct {
+	r, g, b int
+}
+
+// consolePalette is the classic color table of the Windows console
+// indexed by the 4-bit console color, whose bits are blue, green, red and
+// intensity from the lowest.
+var consolePalette = [consoleColorCount]rgb{
+	{0, 0, 0},
+	{0, 0, 128},
+	{0, 128, 0},
+	{0, 128, 128},
+	{128, 0, 0},
+	{128, 0, 128},
+	{128, 128, 0},
+	{192, 192, 192},
+	{128, 128, 128},
+	{0, 0, 255},
+	{0, 255, 0},
+	{0, 255, 255},
+	{255, 0, 0},
+	{255, 0, 255},
+	{255, 255, 0},
+	{255, 255, 255},
+}
+
+// xtermRGB returns the color of the index 16 to 255 of the xterm palette,
+// which is a 6x6x6 color cube followed by a gray scale ramp.
+func xtermRGB(i int) rgb {
+	if i >= 232 {
+		v := 8 + (i-232)*10
+		return rgb{v, v, v}
+	}
+	level := func(v int) int {
+		if v == 0 {
+			return 0
+		}
+		return 55 + v*40
+	}
+	i -= 16
+	return rgb{level(i / 36), level(i / 6 % 6), level(i % 6)}
+}
+
+// ColorMetric selects how the distance between two colors is measured
+// when an extended color is matched to the nearest console color.
+type ColorMetric int
+
+const (
+	// RGBDistance is the Euclidean distance in the sRGB color space.
+	RGBDistance ColorMetric = iota
+	// CIELABDistance is the Euclidean distance in the CIELAB color space
+	// (CIE76), which is closer to the perceived difference.
+	CIELABDistance
+)
+
+type lab struct {
+	l, a, b float64
+}
+
+var consolePaletteLab = func() (table [consoleColorCount]lab) {
+	for i, c := range consolePalette {
+		table[i] = toLab(c)
+	}
+	return table
+}()
+
+// toLab converts an sRGB color to CIELAB with the D65 white point.
+func toLab(c rgb) lab {
+	linear := func(v int) float64 {
+		f := float64(v) / 255
+		if f <= 0.04045 {
+			return f / 12.92
+		}
+		return math.Pow((f+0.055)/1.055, 2.4)
+	}
+	r, g, b := linear(c.r), linear(c.g), linear(c.b)
+	x := (0.4124*r + 0.3576*g + 0.1805*b) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := (0.0193*r + 0.1192*g + 0.9505*b) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return lab{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// maxColorCache limits the number of colors a quantizer remembers.
+const maxColorCache = 1024
+
+// quantizer matches colors to the console colors and caches the results.
+type quantizer struct {
+	metric ColorMetric
+	cache  map[rgb]uint16
+}
+
+func (q *quantizer) nearest(c rgb) uint16 {
+	if color, ok := q.cache[c]; ok {
+		return color
+	}
+	if q.cache == nil || len(q.cache) >= maxColorCache {
+		q.cache = make(map[rgb]uint16)
+	}
+
+	best, bestDist := 0, math.Inf(1)
+	switch q.metric {
+	case CIELABDistance:
+		l := toLab(c)
+		for i, p := range consolePaletteLab {
+			dl, da, db := l.l-p.l, l.a-p.a, l.b-p.b
+			if d := dl*dl + da*da + db*db; d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+	default:
+		for i, p := range consolePalette {
+			dr, dg, db := c.r-p.r, c.g-p.g, c.b-p.b
+			if d := float64(dr*dr + dg*dg + db*db); d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+	}
+	q.cache[c] = uint16(best)
+	return uint16(best)
+}
+
+// xterm256 returns the console color nearest to the index 16 to 255 of the
+// xterm palette. The first 16 are the ANSI colors of the palette of the
+// writer.
+func (q *quantizer) xterm256(index int) uint16 {
+	return q.nearest(xtermRGB(index))
+}
+
+// extendedColor returns the extended color given by 38, 48 or 58 at
+// params[0], and the number of the parameters that it takes. The color is
+// either 5;n of the 256 colors or 2;r;g;b of 24-bit color, separated by
+// semicolons or colons. ok is false if the color is not supported.
+func extendedColor(params []parse.Param) (color Color, n int, ok bool) {
+	var args []int
+	if p := params[0]; len(p) > 1 {
+		// colon separated sub-parameters
+		args, n = p[1:], 1
+		if len(args) > 4 && args[0] == extendedColorRGB {
+			// skip the color space identifier of 38:2:id:r:g:b
+			args = append([]int{extendedColorRGB}, args[2:]...)
+		}
+	} else {
+		for _, p := range params[1:] {
+			args = append(args, p.Value(parse.Default))
+			if len(args) == 1 && args[0] != extendedColor256 && args[0] != extendedColorRGB ||
+				len(args) == 2 && args[0] == extendedColor256 || len(args) == 4 {
+				break
+			}
+		}
+		n = 1 + len(args)
+	}
+
+	value := func(i int) (uint8, bool) {
+		if i >= len(args) {
+			return 0, false
+		}
+		if args[i] == parse.Default {
+			return 0, true
+		}
+		return uint8(args[i]), args[i] < 256
+	}
+	switch {
+	case len(args) == 0:
+		return Color{}, n, false
+	case args[0] == extendedColor256:
+		index, ok := value(1)
+		if !ok {
+			return Color{}, n, false
+		}
+		return IndexedColor(index), n, true
+	case args[0] == extendedColorRGB:
+		r, rok := value(1)
+		g, gok := value(2)
+		b, bok := value(3)
+		if !rok || !gok || !bok {
+			return Color{}, n, false
+		}
+		return RGBColor(r, g, b), n, true
+	}
+	return Color{}, n, false
+}
@@ -0,0 +1,53 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+// Coord is a character cell position in a console screen buffer.
+type Coord struct {
+	X, Y int16
+}
+
+// SmallRect is a rectangle of character cells. All four edges are inclusive.
+type SmallRect struct {
+	Left, Top, Right, Bottom int16
+}
+
+// CharInfo is the content of a single character cell.
+type CharInfo struct {
+	Char       rune
+	Attributes uint16
+}
+
+// ScreenBufferInfo describes the state of a console screen buffer.
+type ScreenBufferInfo struct {
+	Size              Coord
+	CursorPosition    Coord
+	Attributes        uint16
+	Window            SmallRect
+	MaximumWindowSize Coord
+}
+
+// Console is the backend that the escape sequence translator drives.
+// The attributes are Windows console character attributes such as
+// FOREGROUND_RED or BACKGROUND_INTENSITY.
+//
+// On Windows, NewAnsiColorWriter uses a Console backed by kernel32.
+// SimConsole is an in-memory implementation for other systems and tests.
+type Console interface {
+	// ScreenBufferInfo returns the current state of the screen buffer.
+	ScreenBufferInfo() (ScreenBufferInfo, error)
+	// SetTextAttribute sets the attributes of the text written afterwards.
+	SetTextAttribute(attr uint16) error
+	// SetCursorPosition moves the cursor to pos.
+	SetCursorPosition(pos Coord) error
+	// FillOutputCharacter writes ch to n cells starting at pos.
+	FillOutputCharacter(ch rune, n int, pos Coord) error
+	// FillOutputAttribute sets the attributes of n cells starting at pos.
+	FillOutputAttribute(attr uint16, n int, pos Coord) error
+	// ScrollScreenBuffer moves the cells in scroll to dest and fills the
+	// vacated cells with fill. If clip is not nil, only the cells inside
+	// clip are changed.
+	ScrollScreenBuffer(scroll SmallRect, clip *SmallRect, dest Coord, fill CharInfo) error
+}
@@ -0,0 +1,147 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+import "github.com/shiena/ansicolor/parse"
+
+const (
+	cursorUpCode         byte = 'A' // CUU
+	cursorDownCode       byte = 'B' // CUD
+	cursorForwardCode    byte = 'C' // CUF
+	cursorBackCode       byte = 'D' // CUB
+	cursorNextLineCode   byte = 'E' // CNL
+	cursorPrevLineCode   byte = 'F' // CPL
+	cursorColumnCode     byte = 'G' // CHA
+	cursorPositionCode   byte = 'H' // CUP
+	cursorRowCode        byte = 'd' // VPA
+	cursorHVPositionCode byte = 'f' // HVP
+
+	saveCursorCode    byte = 's' // SCOSC
+	restoreCursorCode byte = 'u' // SCORC
+
+	// the finals of ESC 7 and ESC 8
+	decSaveCursorCode    byte = '7' // DECSC
+	decRestoreCursorCode byte = '8' // DECRC
+)
+
+// moveCursor moves the cursor as the control sequence of final with the
+// parameters param. The rows and the columns count from 1 at the top left
+// of the window, and the cursor stays in the window, which is in the
+// buffer. Like xterm, the relative moves up and down stop at the margins
+// of the scrolling region when the cursor starts in it.
+func (cw *ansiColorWriter) moveCursor(final byte, param []byte) parseResult {
+	info, err := cw.console.ScreenBufferInfo()
+	if err != nil {
+		return noConsole
+	}
+
+	params := parse.ParseParams(param)
+	arg := func(i int) int {
+		if i >= len(params) {
+			return 1
+		}
+		if v := params[i].Value(1); v > 0 {
+			return v
+		}
+		return 1
+	}
+	win := info.Window
+	x, y := int(info.CursorPosition.X), int(info.CursorPosition.Y)
+	switch final {
+	case cursorUpCode:
+		y -= arg(0)
+	case cursorDownCode:
+		y += arg(0)
+	case cursorForwardCode:
+		x += arg(0)
+	case cursorBackCode:
+		x -= arg(0)
+	case cursorNextLineCode:
+		x, y = int(win.Left), y+arg(0)
+	case cursorPrevLineCode:
+		x, y = int(win.Left), y-arg(0)
+	case cursorColumnCode:
+		x = int(win.Left) + arg(0) - 1
+	case cursorRowCode:
+		y = int(win.Top) + arg(0) - 1
+	case cursorPositionCode, cursorHVPositionCode:
+		x, y = int(win.Left)+arg(1)-1, int(win.Top)+arg(0)-1
+	}
+
+	minY, maxY := int(win.Top), int(win.Bottom)
+	top, bottom := cw.scrollRows(win)
+	switch final {
+	case cursorUpCode, cursorPrevLineCode:
+		if info.CursorPosition.Y >= top {
+			minY = int(top)
+		}
+	case cursorDownCode, cursorNextLineCode:
+		if info.CursorPosition.Y <= bottom {
+			maxY = int(bottom)
+		}
+	}
+
+	pos := Coord{
+		X: int16(clamp(x, int(win.Left), int(win.Right))),
+		Y: int16(clamp(y, minY, maxY)),
+	}
+	cw.console.SetCursorPosition(pos)
+	return handled
+}
+
+// savedCursor is the cursor position and the style saved by DECSC or
+// SCOSC.
+type savedCursor struct {
+	position Coord
+	style    Style
+	base     baseColors
+}
+
+func (cw *ansiColorWriter) saveCursor() parseResult {
+	info, err := cw.console.ScreenBufferInfo()
+	if err != nil || !cw.syncStyle() {
+		return noConsole
+	}
+	cw.savedCursor = &savedCursor{
+		position: info.CursorPosition,
+		style:    cw.style,
+		base:     cw.base,
+	}
+	return handled
+}
+
+// restoreCursor restores the cursor position and the style saved last.
+// Without a saved cursor, like xterm, it moves the cursor to the top left
+// of the window and resets the style.
+func (cw *ansiColorWriter) restoreCursor() parseResult {
+	info, err := cw.console.ScreenBufferInfo()
+	if err != nil || !cw.syncStyle() {
+		return noConsole
+	}
+	saved := savedCursor{position: Coord{info.Window.Left, info.Window.Top}}
+	if cw.savedCursor != nil {
+		saved = *cw.savedCursor
+	}
+
+	// the buffer may have shrunk since the cursor was saved
+	pos := Coord{
+		X: int16(clamp(int(saved.position.X), 0, int(info.Size.X)-1)),
+		Y: int16(clamp(int(saved.position.Y), 0, int(info.Size.Y)-1)),
+	}
+	cw.console.SetCursorPosition(pos)
+	cw.style, cw.base = saved.style, saved.base
+	cw.showStyle()
+	return handled
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,70 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+import "github.com/shiena/ansicolor/parse"
+
+const (
+	eraseDisplayCode byte = 'J' // ED
+	eraseLineCode    byte = 'K' // EL
+)
+
+// the modes of ED and EL
+const (
+	eraseToEnd       = 0
+	eraseToBeginning = 1
+	eraseAll         = 2
+	eraseScrollback  = 3
+)
+
+// erase fills a part of the window with spaces in the colors of the
+// current attribute as ED or EL of final does. ED 3 erases the scrollback,
+// which is the part of the buffer above the window. The cursor does not
+// move.
+func (cw *ansiColorWriter) erase(final byte, param []byte) parseResult {
+	info, err := cw.console.ScreenBufferInfo()
+	if err != nil {
+		return noConsole
+	}
+
+	mode := eraseToEnd
+	if params := parse.ParseParams(param); len(params) > 0 {
+		mode = params[0].Value(eraseToEnd)
+	}
+	width := int(info.Size.X)
+	win := info.Window
+	x, y := int(info.CursorPosition.X), int(info.CursorPosition.Y)
+	top, bottom := int(win.Top), int(win.Bottom)
+
+	var pos Coord
+	var n int
+	switch {
+	case final == eraseDisplayCode && mode == eraseToEnd:
+		pos, n = info.CursorPosition, (bottom-y)*width+width-x
+	case final == eraseDisplayCode && mode == eraseToBeginning:
+		pos, n = Coord{0, win.Top}, (y-top)*width+x+1
+	case final == eraseDisplayCode && mode == eraseAll:
+		pos, n = Coord{0, win.Top}, (bottom-top+1)*width
+	case final == eraseDisplayCode && mode == eraseScrollback:
+		pos, n = Coord{0, 0}, top*width
+	case final == eraseLineCode && mode == eraseToEnd:
+		pos, n = info.CursorPosition, width-x
+	case final == eraseLineCode && mode == eraseToBeginning:
+		pos, n = Coord{0, info.CursorPosition.Y}, x+1
+	case final == eraseLineCode && mode == eraseAll:
+		pos, n = Coord{0, info.CursorPosition.Y}, width
+	default:
+		return unknown
+	}
+	if n <= 0 {
+		// the cursor is out of the window
+		return handled
+	}
+
+	attr := info.Attributes & (foregroundMask | backgroundMask)
+	cw.console.FillOutputCharacter(' ', n, pos)
+	cw.console.FillOutputAttribute(attr, n, pos)
+	return handled
+}
@@ -9,10 +9,24 @@ package ansicolor
 
 import "syscall"
 
-var GetConsoleScreenBufferInfo = getConsoleScreenBufferInfo
+func GetConsoleScreenBufferInfo(hConsoleOutput uintptr) *consoleScreenBufferInfo {
+	info, err := winConsole(hConsoleOutput).ScreenBufferInfo()
+	if err != nil {
+		return nil
+	}
+	return &consoleScreenBufferInfo{
+		DwSize:              info.Size,
+		DwCursorPosition:    info.CursorPosition,
+		WAttributes:         info.Attributes,
+		SrWindow:            info.Window,
+		DwMaximumWindowSize: info.MaximumWindowSize,
+	}
+}
+
+var StdoutConsole Console = winConsole(syscall.Stdout)
 
 func ChangeColor(color uint16) {
-	setConsoleTextAttribute(uintptr(syscall.Stdout), color)
+	StdoutConsole.SetTextAttribute(color)
 }
 
 func ResetColor() {
@@ -0,0 +1,71 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+// Palette maps the ANSI colors to the 4-bit console colors, whose bits are
+// blue, green, red and intensity from the lowest.
+type Palette struct {
+	// Foreground holds the colors of SGR 30 to 37 followed by 90 to 97.
+	Foreground [16]uint16
+	// Background holds the colors of SGR 40 to 47 followed by 100 to 107.
+	Background [16]uint16
+	// DefaultForeground and DefaultBackground are the colors of SGR 39 and
+	// 49 and of the reset when HasDefaultForeground and
+	// HasDefaultBackground are set. Otherwise they are the colors that the
+	// console has when the writer is created.
+	DefaultForeground    uint16
+	DefaultBackground    uint16
+	HasDefaultForeground bool
+	HasDefaultBackground bool
+}
+
+// DefaultPalette is the classic mapping of the console.
+var DefaultPalette = Palette{
+	Foreground: [16]uint16{0, 4, 2, 6, 1, 5, 3, 7, 8, 12, 10, 14, 9, 13, 11, 15},
+	Background: [16]uint16{0, 4, 2, 6, 1, 5, 3, 7, 8, 12, 10, 14, 9, 13, 11, 15},
+}
+
+// LightPalette is for a console with a light background. The light
+// foreground colors are shown as the dark ones, yellow, which is hard to
+// read on white even when dark, as dark red, white as gray and bright
+// white as black.
+var LightPalette = Palette{
+	Foreground: [16]uint16{0, 4, 2, 4, 1, 5, 3, 8, 8, 4, 2, 4, 1, 5, 3, 0},
+	Background: [16]uint16{0, 4, 2, 6, 1, 5, 3, 7, 8, 12, 10, 14, 9, 13, 11, 15},
+}
+
+// MonochromePalette shows every foreground color as white or bright white
+// and every background color as black or gray.
+var MonochromePalette = Palette{
+	Foreground:           [16]uint16{7, 7, 7, 7, 7, 7, 7, 7, 15, 15, 15, 15, 15, 15, 15, 15},
+	Background:           [16]uint16{0, 0, 0, 0, 0, 0, 0, 0, 8, 8, 8, 8, 8, 8, 8, 8},
+	DefaultForeground:    7,
+	DefaultBackground:    0,
+	HasDefaultForeground: true,
+	HasDefaultBackground: true,
+}
+
+// WithPalette makes the writer map the ANSI colors with p instead of
+// DefaultPalette. It also maps the first 16 of the 256 colors, which are
+// the ANSI colors, but not the others or the 24-bit colors.
+func WithPalette(p Palette) Option {
+	return func(cw *ansiColorWriter) {
+		cw.palette = p
+	}
+}
+
+// defaultColors returns the default foreground and background colors of
+// p, taking the omitted ones from textAttr.
+func (p *Palette) defaultColors(textAttr *textAttributes) (fg, bg uint16) {
+	fg = textAttr.foregroundColor | textAttr.foregroundIntensity
+	if p.HasDefaultForeground {
+		fg = p.DefaultForeground & 0xf
+	}
+	bg = (textAttr.backgroundColor | textAttr.backgroundIntensity) >> 4
+	if p.HasDefaultBackground {
+		bg = p.DefaultBackground & 0xf
+	}
+	return fg, bg
+}
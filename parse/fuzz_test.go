@@ -0,0 +1,61 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package parse_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/shiena/ansicolor/parse"
+)
+
+func FuzzTokenizerLimits(f *testing.F) {
+	f.Add([]byte("\x1b["+string(bytes.Repeat([]byte(";"), 10000))+"m"), 7)
+	f.Add([]byte("\x1b["+string(bytes.Repeat([]byte("9"), 10000))), 1)
+	f.Add([]byte("\x1b]0;"+string(bytes.Repeat([]byte("t"), 10000))+"\x07"), 13)
+	f.Add([]byte("\x1bP"+string(bytes.Repeat([]byte("1:"), 10000))+"q"), 64)
+	f.Add([]byte("\x9b31m\x9d0;caf\xe9\x9c\x1b(B"), 3)
+
+	const (
+		maxStringLen   = 64
+		maxSequenceLen = 32
+		maxParams      = 8
+	)
+	f.Fuzz(func(t *testing.T, data []byte, chunk int) {
+		if chunk <= 0 {
+			chunk = 1
+		}
+		tok := &parse.Tokenizer{
+			C1:             chunk%2 == 0,
+			MaxStringLen:   maxStringLen,
+			MaxSequenceLen: maxSequenceLen,
+			MaxParams:      maxParams,
+		}
+		// a string is its introducer of at most maxSequenceLen bytes, the
+		// payload and ESC of the terminator
+		const bound = maxSequenceLen + maxStringLen + 1
+
+		for len(data) > 0 {
+			n := chunk
+			if n > len(data) {
+				n = len(data)
+			}
+			tok.Feed(data[:n], func(tk parse.Token) error {
+				// the rest of an overflowed sequence is a part of the input
+				if tk.Kind != parse.Text && len(tk.Raw) > bound+1 && !(tk.Overflow && len(tk.Raw) <= n) {
+					t.Fatalf("Get a token of %d bytes, want at most %d", len(tk.Raw), bound+1)
+				}
+				if tk.Kind == parse.CSI && len(parse.ParseParams(tk.Params)) > maxParams {
+					t.Fatalf("Get %d parameters, want at most %d", len(parse.ParseParams(tk.Params)), maxParams)
+				}
+				return nil
+			})
+			if pending := tok.Pending(); len(pending) > bound {
+				t.Fatalf("Get %d pending bytes, want at most %d", len(pending), bound)
+			}
+			data = data[n:]
+		}
+	})
+}
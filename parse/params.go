@@ -0,0 +1,63 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package parse
+
+// Default is the value of an omitted parameter or sub-parameter.
+const Default = -1
+
+// maxParamValue is the value of a parameter too large to represent.
+const maxParamValue = 1<<16 - 1
+
+// Param is a parameter of a control sequence. Param[0] is the value of the
+// parameter and the rest are its sub-parameters as defined by ITU T.416,
+// so "38:2::255:128:0" becomes Param{38, 2, Default, 255, 128, 0}.
+type Param []int
+
+// Value returns the value of the parameter, or def if it is omitted.
+func (p Param) Value(def int) int {
+	if len(p) == 0 || p[0] == Default {
+		return def
+	}
+	return p[0]
+}
+
+// Sub returns the i-th sub-parameter counted from 1, or def if it is
+// omitted.
+func (p Param) Sub(i, def int) int {
+	if i >= len(p) || p[i] == Default {
+		return def
+	}
+	return p[i]
+}
+
+// ParseParams splits the parameter bytes of a control sequence into
+// parameters separated by ';' and sub-parameters separated by ':'.
+// Values larger than 65535 are clamped and other bytes are ignored.
+// It returns nil when b is empty.
+func ParseParams(b []byte) []Param {
+	if len(b) == 0 {
+		return nil
+	}
+	var params []Param
+	p := Param{Default}
+	for _, c := range b {
+		switch {
+		case '0' <= c && c <= '9':
+			v := &p[len(p)-1]
+			if *v == Default {
+				*v = 0
+			}
+			if *v = *v*10 + int(c-'0'); *v > maxParamValue {
+				*v = maxParamValue
+			}
+		case c == ':':
+			p = append(p, Default)
+		case c == ';':
+			params = append(params, p)
+			p = Param{Default}
+		}
+	}
+	return append(params, p)
+}
@@ -0,0 +1,49 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package parse_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shiena/ansicolor/parse"
+)
+
+func TestParseParams(t *testing.T) {
+	d := parse.Default
+	tests := []struct {
+		input    string
+		expected []parse.Param
+	}{
+		{"", nil},
+		{"0", []parse.Param{{0}}},
+		{"1;;31", []parse.Param{{1}, {d}, {31}}},
+		{"38;5;208", []parse.Param{{38}, {5}, {208}}},
+		{"38:2::255:128:0", []parse.Param{{38, 2, d, 255, 128, 0}}},
+		{"4:3;58:5:1", []parse.Param{{4, 3}, {58, 5, 1}}},
+		{"99999999", []parse.Param{{65535}}},
+	}
+	for _, v := range tests {
+		if actual := parse.ParseParams([]byte(v.input)); !reflect.DeepEqual(actual, v.expected) {
+			t.Errorf("Input %q: Get %v, want %v", v.input, actual, v.expected)
+		}
+	}
+}
+
+func TestParamValue(t *testing.T) {
+	p := parse.ParseParams([]byte("38:2::255"))[0]
+	if actual := p.Value(0); actual != 38 {
+		t.Errorf("Get %d, want %d", actual, 38)
+	}
+	if actual := p.Sub(2, 7); actual != 7 {
+		t.Errorf("Get %d, want %d", actual, 7)
+	}
+	if actual := p.Sub(3, 7); actual != 255 {
+		t.Errorf("Get %d, want %d", actual, 255)
+	}
+	if actual := p.Sub(4, 7); actual != 7 {
+		t.Errorf("Get %d, want %d", actual, 7)
+	}
+}
@@ -0,0 +1,534 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package parse provides a streaming tokenizer for text that contains
+// ECMA-48 control functions such as the ANSI escape sequences.
+package parse
+
+import "strconv"
+
+// Kind is the type of a Token.
+type Kind int
+
+const (
+	// Text is a run of printable bytes.
+	Text Kind = iota
+	// Control is a single C0 control byte other than ESC, or a C1 control
+	// byte that does not introduce a sequence.
+	Control
+	// Esc is an escape sequence that is not one of the other kinds,
+	// such as ESC 7 or ESC ( B.
+	Esc
+	// CSI is a control sequence such as ESC [ 31 m.
+	CSI
+	// OSC is an operating system command such as ESC ] 0 ; title BEL.
+	OSC
+	// DCS is a device control string.
+	DCS
+	// SOS is a start of string sequence.
+	SOS
+	// PM is a privacy message.
+	PM
+	// APC is an application program command.
+	APC
+)
+
+var kindNames = [...]string{"Text", "Control", "Esc", "CSI", "OSC", "DCS", "SOS", "PM", "APC"}
+
+func (k Kind) String() string {
+	if 0 <= k && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Token is a piece of the input returned by a Tokenizer.
+// The byte slices are only valid until the callback that received the
+// Token returns.
+type Token struct {
+	Kind Kind
+	// Raw is the complete token as it appeared in the input.
+	Raw []byte
+	// Params holds the parameter bytes (0x30-0x3F) of CSI and DCS,
+	// including private markers such as '?'.
+	Params []byte
+	// Intermediates holds the intermediate bytes (0x20-0x2F) of Esc, CSI
+	// and DCS.
+	Intermediates []byte
+	// Final is the final byte of Esc, CSI and DCS.
+	Final byte
+	// Data is the text of Text and the payload of OSC, DCS, SOS, PM and APC
+	// without the introducer and the terminator.
+	Data []byte
+	// Pos is the offset of the token in the input of the call to Feed
+	// that returned it. It is negative if the token started -Pos bytes
+	// before that input.
+	Pos int
+	// Overflow reports that the sequence exceeds a limit of the Tokenizer
+	// and Raw holds only the beginning of it. The rest of a string (OSC,
+	// DCS, SOS, PM or APC) up to the terminator is skipped. The rest of
+	// another sequence up to its final byte follows in tokens of the same
+	// Kind with Overflow set, whose Raw is a part of the input.
+	Overflow bool
+	// Aborted reports that the Text is the beginning of a sequence
+	// cancelled by CAN or SUB, or broken off by a byte of 0x80 or above.
+	// A sequence interrupted by ESC is returned as plain Text.
+	Aborted bool
+}
+
+const (
+	bel byte = 0x07
+	can byte = 0x18
+	sub byte = 0x1a
+	esc byte = 0x1b
+	del byte = 0x7f
+
+	// 8-bit C1 controls
+	dcs8 byte = 0x90
+	sos8 byte = 0x98
+	csi8 byte = 0x9b
+	st8  byte = 0x9c
+	osc8 byte = 0x9d
+	pm8  byte = 0x9e
+	apc8 byte = 0x9f
+)
+
+type state int
+
+const (
+	ground state = iota
+	escape
+	escapeIntermediate
+	csiParam
+	csiIntermediate
+	dcsParam
+	dcsIntermediate
+	stringData
+	stringEscape
+	ignore
+)
+
+// Tokenizer splits a byte stream into Tokens. A sequence may be divided
+// across any number of calls to Feed. The zero value is ready to use.
+//
+// Bytes of a sequence that is interrupted by ESC, CAN, SUB or a byte that
+// cannot continue it are returned as Text, so no input is lost. Other C0
+// controls inside a sequence are returned immediately as Control, as a
+// terminal executes them. DEL is ignored inside a sequence.
+// A string (OSC, DCS, SOS, PM or APC) ends at ST, at BEL for OSC, or at
+// any ESC, CAN or SUB that interrupts it.
+type Tokenizer struct {
+	// C1 makes the 8-bit C1 controls 0x80-0x9F act like their 7-bit
+	// ESC forms, so 0x9B introduces a CSI and 0x9C terminates a string.
+	// It is for a stream that is not UTF-8, such as Latin-1, as a byte of
+	// a UTF-8 encoded character may be in the same range.
+	C1 bool
+	// MaxStringLen limits the length of the payload of a string that is
+	// kept in memory. Zero means no limit.
+	MaxStringLen int
+	// MaxSequenceLen limits the length of a sequence other than the
+	// payload of a string, and MaxParams limits the number of parameters
+	// and sub-parameters of a CSI or DCS. Zero means no limit.
+	MaxSequenceLen int
+	MaxParams      int
+
+	state   state
+	kind    Kind
+	buf     []byte
+	skip    bool
+	nparams int
+
+	// the offset of the sequence in the input
+	start int
+
+	paramStart int
+	interStart int
+	finalPos   int
+	dataStart  int
+}
+
+// NewTokenizer returns a new Tokenizer.
+func NewTokenizer() *Tokenizer {
+	return &Tokenizer{}
+}
+
+// Pending returns the bytes of the incomplete sequence at the end of the
+// input so far. It returns nil while skipping the rest of a string that
+// overflowed.
+func (t *Tokenizer) Pending() []byte {
+	if t.skip {
+		return nil
+	}
+	return t.buf
+}
+
+// Reset discards the incomplete sequence, if any.
+func (t *Tokenizer) Reset() {
+	t.state = ground
+	t.skip = false
+	t.buf = t.buf[:0]
+}
+
+func (t *Tokenizer) begin(b byte) {
+	t.buf = append(t.buf[:0], b)
+	t.state = escape
+}
+
+// Feed tokenizes p and calls fn for every complete token in order.
+// It returns len(p) and nil when fn never fails. When fn returns an error,
+// Feed resets the Tokenizer and returns the error with the offset in p at
+// which the failed token started, or 0 if it started before p.
+func (t *Tokenizer) Feed(p []byte, fn func(Token) error) (int, error) {
+	textStart := -1
+	for i := 0; i < len(p); {
+		b := p[i]
+
+		if t.state == ignore {
+			n, err := t.ignore(p, i, fn)
+			if err != nil {
+				return n, t.fail(err)
+			}
+			i = n
+			continue
+		}
+		if t.state != ground {
+			reprocess, err := t.step(p, i, fn)
+			if err != nil {
+				// the bytes of the sequence are discarded
+				if t.start < 0 {
+					return 0, t.fail(err)
+				}
+				return t.start, t.fail(err)
+			}
+			if !reprocess {
+				i++
+			}
+			continue
+		}
+
+		if b != esc && b >= 0x20 && !t.c1(b) {
+			if textStart < 0 {
+				textStart = i
+			}
+			i++
+			continue
+		}
+		if textStart >= 0 {
+			text := p[textStart:i]
+			if err := fn(Token{Kind: Text, Raw: text, Data: text, Pos: textStart}); err != nil {
+				return textStart, t.fail(err)
+			}
+			textStart = -1
+		}
+		if b == esc || b >= 0x80 && t.beginC1(b) {
+			t.start = i
+			if b == esc {
+				t.begin(b)
+			}
+		} else if err := fn(Token{Kind: Control, Raw: p[i : i+1], Pos: i}); err != nil {
+			return i, t.fail(err)
+		}
+		i++
+	}
+	if textStart >= 0 {
+		text := p[textStart:]
+		if err := fn(Token{Kind: Text, Raw: text, Data: text, Pos: textStart}); err != nil {
+			return textStart, t.fail(err)
+		}
+	}
+	// the pending sequence starts before the next input
+	t.start -= len(p)
+	return len(p), nil
+}
+
+func (t *Tokenizer) fail(err error) error {
+	t.Reset()
+	return err
+}
+
+// step advances a sequence by the byte of p at i. It reports whether the
+// byte has to be processed again in the new state.
+func (t *Tokenizer) step(p []byte, i int, fn func(Token) error) (bool, error) {
+	raw := p[i : i+1]
+	b := raw[0]
+
+	switch t.state {
+	case stringData:
+		switch {
+		case t.c1(b) && b == st8:
+			t.buf = append(t.buf, b)
+			return false, t.dispatchString(len(t.buf)-1, fn)
+		case b == esc:
+			t.buf = append(t.buf, b)
+			t.state = stringEscape
+		case b == bel && t.kind == OSC:
+			t.buf = append(t.buf, b)
+			return false, t.dispatchString(len(t.buf)-1, fn)
+		case b == can || b == sub:
+			return true, t.dispatchString(len(t.buf), fn)
+		case t.skip:
+		default:
+			t.buf = append(t.buf, b)
+			if t.MaxStringLen > 0 && len(t.buf)-t.dataStart > t.MaxStringLen {
+				return false, t.overflowString(fn)
+			}
+		}
+		return false, nil
+	case stringEscape:
+		if b == '\\' {
+			t.buf = append(t.buf, b)
+			return false, t.dispatchString(len(t.buf)-2, fn)
+		}
+		t.buf = t.buf[:len(t.buf)-1]
+		if err := t.dispatchString(len(t.buf), fn); err != nil {
+			return false, err
+		}
+		// the ESC that interrupted the string starts a new sequence
+		t.begin(esc)
+		t.start = i - 1
+		return true, nil
+	}
+
+	switch {
+	case b == esc:
+		return true, t.abort(false, fn)
+	case b == can || b == sub || b >= 0x80:
+		return true, t.abort(true, fn)
+	case b == del:
+		return false, nil
+	case b < 0x20:
+		return false, fn(Token{Kind: Control, Raw: raw, Pos: i})
+	case t.MaxSequenceLen > 0 && len(t.buf) >= t.MaxSequenceLen:
+		return true, t.overflowSequence(fn)
+	case (b == ';' || b == ':') && (t.state == csiParam || t.state == dcsParam):
+		if t.nparams++; t.MaxParams > 0 && t.nparams > t.MaxParams {
+			return true, t.overflowSequence(fn)
+		}
+	}
+
+	switch t.state {
+	case escape:
+		t.buf = append(t.buf, b)
+		switch {
+		case b < 0x30:
+			t.interStart = 1
+			t.state = escapeIntermediate
+		case b == '[':
+			t.enterParam(csiParam)
+		case b == 'P':
+			t.enterParam(dcsParam)
+		case b == ']':
+			t.enterString(OSC)
+		case b == 'X':
+			t.enterString(SOS)
+		case b == '^':
+			t.enterString(PM)
+		case b == '_':
+			t.enterString(APC)
+		default:
+			return false, t.dispatch(Token{Kind: Esc, Raw: t.buf, Final: b}, fn)
+		}
+	case escapeIntermediate:
+		t.buf = append(t.buf, b)
+		if b >= 0x30 {
+			return false, t.dispatch(Token{
+				Kind:          Esc,
+				Raw:           t.buf,
+				Intermediates: t.buf[t.interStart : len(t.buf)-1],
+				Final:         b,
+			}, fn)
+		}
+	case csiParam, csiIntermediate, dcsParam, dcsIntermediate:
+		t.buf = append(t.buf, b)
+		switch {
+		case b < 0x30:
+			if t.state == csiParam || t.state == dcsParam {
+				t.interStart = len(t.buf) - 1
+				t.state++
+			}
+		case b < 0x40:
+			// a parameter byte after an intermediate byte is malformed
+			// and is kept with the intermediates
+		case t.state == csiParam || t.state == csiIntermediate:
+			return false, t.dispatch(t.header(CSI), fn)
+		default:
+			t.finalPos = len(t.buf) - 1
+			t.dataStart = len(t.buf)
+			t.kind = DCS
+			t.state = stringData
+		}
+	}
+	return false, nil
+}
+
+// c1 reports whether b is a C1 control. It is always false unless C1 is
+// set.
+func (t *Tokenizer) c1(b byte) bool {
+	return t.C1 && 0x80 <= b && b <= 0x9f
+}
+
+// beginC1 starts the sequence introduced by the C1 control b. It reports
+// false if b does not introduce a sequence.
+func (t *Tokenizer) beginC1(b byte) bool {
+	t.buf = append(t.buf[:0], b)
+	switch b {
+	case csi8:
+		t.enterParam(csiParam)
+	case dcs8:
+		t.enterParam(dcsParam)
+	case osc8:
+		t.enterString(OSC)
+	case sos8:
+		t.enterString(SOS)
+	case pm8:
+		t.enterString(PM)
+	case apc8:
+		t.enterString(APC)
+	default:
+		t.buf = t.buf[:0]
+		return false
+	}
+	return true
+}
+
+func (t *Tokenizer) enterParam(s state) {
+	t.paramStart = len(t.buf)
+	t.nparams = 1
+	t.interStart = -1
+	t.state = s
+}
+
+func (t *Tokenizer) enterString(k Kind) {
+	t.kind = k
+	t.finalPos = -1
+	t.dataStart = len(t.buf)
+	t.state = stringData
+}
+
+// header returns the token of the CSI or DCS header in buf. The final byte
+// of a CSI is the last byte of buf, and that of a DCS is at finalPos.
+func (t *Tokenizer) header(k Kind) Token {
+	final := len(t.buf) - 1
+	if k == DCS {
+		final = t.finalPos
+	}
+	interStart := t.interStart
+	if interStart < 0 {
+		interStart = final
+	}
+	return Token{
+		Kind:          k,
+		Raw:           t.buf,
+		Params:        t.buf[t.paramStart:interStart],
+		Intermediates: t.buf[interStart:final],
+		Final:         t.buf[final],
+	}
+}
+
+func (t *Tokenizer) stringToken(dataEnd int) Token {
+	tok := Token{Kind: t.kind, Raw: t.buf}
+	if t.kind == DCS {
+		tok = t.header(DCS)
+	}
+	tok.Data = t.buf[t.dataStart:dataEnd]
+	return tok
+}
+
+func (t *Tokenizer) dispatchString(dataEnd int, fn func(Token) error) error {
+	if t.skip {
+		t.Reset()
+		return nil
+	}
+	return t.dispatch(t.stringToken(dataEnd), fn)
+}
+
+// overflowString returns the beginning of a string that is too long and
+// skips the rest of it.
+func (t *Tokenizer) overflowString(fn func(Token) error) error {
+	tok := t.stringToken(len(t.buf))
+	tok.Overflow = true
+	tok.Pos = t.start
+	err := fn(tok)
+	t.buf = t.buf[:0]
+	t.skip = true
+	return err
+}
+
+func (t *Tokenizer) dispatch(tok Token, fn func(Token) error) error {
+	tok.Pos = t.start
+	err := fn(tok)
+	t.Reset()
+	return err
+}
+
+// overflowSequence returns the beginning of a sequence that is too long.
+// The rest of a DCS is skipped as a string, and that of another sequence
+// is ignored up to its final byte.
+func (t *Tokenizer) overflowSequence(fn func(Token) error) error {
+	kind := Esc
+	switch t.state {
+	case csiParam, csiIntermediate:
+		kind = CSI
+	case dcsParam, dcsIntermediate:
+		kind = DCS
+	}
+	err := fn(Token{Kind: kind, Raw: t.buf, Pos: t.start, Overflow: true})
+	t.Reset()
+	t.kind = kind
+	if kind == DCS {
+		t.state = stringData
+		t.skip = true
+	} else {
+		t.state = ignore
+	}
+	return err
+}
+
+// ignore returns the rest of a CSI or an escape sequence that is too long
+// from the offset i of p, and returns the offset after it, or that of the
+// failed token on error. The sequence ends after its final byte, or before
+// a byte that cannot continue it. Other C0 controls are returned as
+// Control.
+func (t *Tokenizer) ignore(p []byte, i int, fn func(Token) error) (int, error) {
+	// the parameter and intermediate bytes of a CSI, or the intermediate
+	// bytes of an escape sequence, are below finalMin
+	finalMin := byte(0x30)
+	if t.kind == CSI {
+		finalMin = 0x40
+	}
+	n := i
+	for n < len(p) && (0x20 <= p[n] && p[n] < finalMin || p[n] == del) {
+		n++
+	}
+	final := n < len(p) && finalMin <= p[n] && p[n] < del
+	if final {
+		n++
+	}
+	if n > i {
+		if err := fn(Token{Kind: t.kind, Raw: p[i:n], Pos: i, Overflow: true}); err != nil {
+			return i, err
+		}
+	}
+
+	switch {
+	case final:
+		t.Reset()
+	case n == len(p):
+		// the sequence continues in the next input
+	case p[n] < 0x20 && p[n] != esc && p[n] != can && p[n] != sub:
+		if err := fn(Token{Kind: Control, Raw: p[n : n+1], Pos: n}); err != nil {
+			return n, err
+		}
+		n++
+	default:
+		t.Reset()
+	}
+	return n, nil
+}
+
+// abort returns the bytes of the interrupted sequence as Text, marked as
+// Aborted if the sequence is cancelled.
+func (t *Tokenizer) abort(cancelled bool, fn func(Token) error) error {
+	return t.dispatch(Token{Kind: Text, Raw: t.buf, Data: t.buf, Aborted: cancelled}, fn)
+}
@@ -0,0 +1,329 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package parse_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/shiena/ansicolor/parse"
+)
+
+type token struct {
+	Kind          parse.Kind
+	Raw           string
+	Params        string
+	Intermediates string
+	Final         byte
+	Data          string
+	Overflow      bool
+	Aborted       bool
+}
+
+func text(s string) token {
+	return token{Kind: parse.Text, Raw: s, Data: s}
+}
+
+func aborted(s string) token {
+	return token{Kind: parse.Text, Raw: s, Data: s, Aborted: true}
+}
+
+// collect feeds every chunk to tok and returns the tokens, with adjacent
+// Text tokens that are not Aborted and the rest of an overflowed sequence joined so that the
+// result does not depend on the chunking.
+func collect(tok *parse.Tokenizer, chunks ...string) []token {
+	var tokens []token
+	for _, chunk := range chunks {
+		tok.Feed([]byte(chunk), func(t parse.Token) error {
+			if n := len(tokens); n > 0 && t.Kind == parse.Text && !t.Aborted &&
+				tokens[n-1].Kind == parse.Text && !tokens[n-1].Aborted {
+				tokens[n-1] = text(tokens[n-1].Raw + string(t.Raw))
+				return nil
+			}
+			if n := len(tokens); n > 0 && t.Overflow && (t.Kind == parse.CSI || t.Kind == parse.Esc) &&
+				tokens[n-1].Overflow && tokens[n-1].Kind == t.Kind {
+				tokens[n-1].Raw += string(t.Raw)
+				return nil
+			}
+			tokens = append(tokens, token{
+				Kind:          t.Kind,
+				Raw:           string(t.Raw),
+				Params:        string(t.Params),
+				Intermediates: string(t.Intermediates),
+				Final:         t.Final,
+				Data:          string(t.Data),
+				Overflow:      t.Overflow,
+				Aborted:       t.Aborted,
+			})
+			return nil
+		})
+	}
+	return tokens
+}
+
+func bytewise(s string) []string {
+	chunks := make([]string, len(s))
+	for i := 0; i < len(s); i++ {
+		chunks[i] = s[i : i+1]
+	}
+	return chunks
+}
+
+var tokenizeTests = []struct {
+	input    string
+	expected []token
+}{
+	{"plain text", []token{text("plain text")}},
+	{"a\r\nb", []token{
+		text("a"),
+		{Kind: parse.Control, Raw: "\r"},
+		{Kind: parse.Control, Raw: "\n"},
+		text("b"),
+	}},
+	{"\x1b[31mred", []token{
+		{Kind: parse.CSI, Raw: "\x1b[31m", Params: "31", Final: 'm'},
+		text("red"),
+	}},
+	{"\x1b[m", []token{{Kind: parse.CSI, Raw: "\x1b[m", Final: 'm'}}},
+	{"\x1b[?25l", []token{{Kind: parse.CSI, Raw: "\x1b[?25l", Params: "?25", Final: 'l'}}},
+	{"\x1b[2 q", []token{{Kind: parse.CSI, Raw: "\x1b[2 q", Params: "2", Intermediates: " ", Final: 'q'}}},
+	{"\x1b[!p", []token{{Kind: parse.CSI, Raw: "\x1b[!p", Intermediates: "!", Final: 'p'}}},
+	{"\x1b[38:2::1:2:3m", []token{{Kind: parse.CSI, Raw: "\x1b[38:2::1:2:3m", Params: "38:2::1:2:3", Final: 'm'}}},
+	{"\x1b[3\n1m", []token{
+		{Kind: parse.Control, Raw: "\n"},
+		{Kind: parse.CSI, Raw: "\x1b[31m", Params: "31", Final: 'm'},
+	}},
+	{"\x1b7\x1b(B", []token{
+		{Kind: parse.Esc, Raw: "\x1b7", Final: '7'},
+		{Kind: parse.Esc, Raw: "\x1b(B", Intermediates: "(", Final: 'B'},
+	}},
+	{"\x1b]0;title\x07", []token{{Kind: parse.OSC, Raw: "\x1b]0;title\x07", Data: "0;title"}}},
+	{"\x1b]8;;http://example.com\x1b\\link", []token{
+		{Kind: parse.OSC, Raw: "\x1b]8;;http://example.com\x1b\\", Data: "8;;http://example.com"},
+		text("link"),
+	}},
+	{"\x1bPq#0;2;0;0;0\x07\x1b\\", []token{
+		{Kind: parse.DCS, Raw: "\x1bPq#0;2;0;0;0\x07\x1b\\", Final: 'q', Data: "#0;2;0;0;0\x07"},
+	}},
+	{"\x1bP1$r0m\x1b\\", []token{
+		{Kind: parse.DCS, Raw: "\x1bP1$r0m\x1b\\", Params: "1", Intermediates: "$", Final: 'r', Data: "0m"},
+	}},
+	{"\x1bXsos\x1b\\\x1b^pm\x1b\\\x1b_apc\x1b\\", []token{
+		{Kind: parse.SOS, Raw: "\x1bXsos\x1b\\", Data: "sos"},
+		{Kind: parse.PM, Raw: "\x1b^pm\x1b\\", Data: "pm"},
+		{Kind: parse.APC, Raw: "\x1b_apc\x1b\\", Data: "apc"},
+	}},
+	{"\x1b]0;unterminated\x1b[0m", []token{
+		{Kind: parse.OSC, Raw: "\x1b]0;unterminated", Data: "0;unterminated"},
+		{Kind: parse.CSI, Raw: "\x1b[0m", Params: "0", Final: 'm'},
+	}},
+	{"\x1b\x1b\x1b[0m many esc", []token{
+		text("\x1b\x1b"),
+		{Kind: parse.CSI, Raw: "\x1b[0m", Params: "0", Final: 'm'},
+		text(" many esc"),
+	}},
+	{"\x1b[3é", []token{aborted("\x1b[3"), text("é")}},
+	{"\x1b[1\x18", []token{aborted("\x1b[1"), {Kind: parse.Control, Raw: "\x18"}}},
+	{"\x1b\x1a", []token{aborted("\x1b"), {Kind: parse.Control, Raw: "\x1a"}}},
+}
+
+func TestTokenize(t *testing.T) {
+	for _, v := range tokenizeTests {
+		actual := collect(parse.NewTokenizer(), v.input)
+		if !reflect.DeepEqual(actual, v.expected) {
+			t.Errorf("Input %q: Get %+v, want %+v", v.input, actual, v.expected)
+		}
+	}
+}
+
+func TestTokenizeSplit(t *testing.T) {
+	for _, v := range tokenizeTests {
+		actual := collect(parse.NewTokenizer(), bytewise(v.input)...)
+		if !reflect.DeepEqual(actual, v.expected) {
+			t.Errorf("Input %q: Get %+v, want %+v", v.input, actual, v.expected)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	tok := parse.NewTokenizer()
+	collect(tok, "text\x1b[3")
+	if actual := string(tok.Pending()); actual != "\x1b[3" {
+		t.Errorf("Get %q, want %q", actual, "\x1b[3")
+	}
+	tok.Reset()
+	if actual := collect(tok, "1m"); !reflect.DeepEqual(actual, []token{text("1m")}) {
+		t.Errorf("Get %+v, want %+v", actual, []token{text("1m")})
+	}
+}
+
+func TestFeedError(t *testing.T) {
+	errStop := errors.New("stop")
+	tests := []struct {
+		input string
+		stop  parse.Kind
+		n     int
+	}{
+		{"abc\x1b[0mdef", parse.CSI, 3},
+		{"abc\x1b[0mdef", parse.Text, 0},
+		{"\x1b[0mdef", parse.Text, 4},
+		{"a\x1b[1\nm", parse.Control, 1},
+	}
+	for _, v := range tests {
+		tok := parse.NewTokenizer()
+		n, err := tok.Feed([]byte(v.input), func(t parse.Token) error {
+			if t.Kind == v.stop {
+				return errStop
+			}
+			return nil
+		})
+		if n != v.n || err != errStop {
+			t.Errorf("Input %q: Get %d, %v, want %d, %v", v.input, n, err, v.n, errStop)
+		}
+		if len(tok.Pending()) != 0 {
+			t.Errorf("Input %q: Get pending %q, want none", v.input, tok.Pending())
+		}
+	}
+}
+
+func TestTokenizeC1(t *testing.T) {
+	tests := []struct {
+		input    string
+		c1       bool
+		expected []token
+	}{
+		{"\x9b31mred", false, []token{text("\x9b31mred")}},
+		{"\x9b31mred\x9d0;title\x9c", true, []token{
+			{Kind: parse.CSI, Raw: "\x9b31m", Params: "31", Final: 'm'},
+			text("red"),
+			{Kind: parse.OSC, Raw: "\x9d0;title\x9c", Data: "0;title"},
+		}},
+		{"\x90q#1\x9c\x9fapc\x1b\\", true, []token{
+			{Kind: parse.DCS, Raw: "\x90q#1\x9c", Final: 'q', Data: "#1"},
+			{Kind: parse.APC, Raw: "\x9fapc\x1b\\", Data: "apc"},
+		}},
+		{"a\x85b", true, []token{text("a"), {Kind: parse.Control, Raw: "\x85"}, text("b")}},
+		// Latin-1 text
+		{"caf\xe9\x9b31mred", true, []token{
+			text("caf\xe9"),
+			{Kind: parse.CSI, Raw: "\x9b31m", Params: "31", Final: 'm'},
+			text("red"),
+		}},
+		{"\x9d0;caf\xe9\x9cbuild", true, []token{
+			{Kind: parse.OSC, Raw: "\x9d0;caf\xe9\x9c", Data: "0;caf\xe9"},
+			text("build"),
+		}},
+		{"\xc5A\xff\x9bm", true, []token{text("\xc5A\xff"), {Kind: parse.CSI, Raw: "\x9bm", Final: 'm'}}},
+	}
+	for _, v := range tests {
+		for _, chunks := range [][]string{{v.input}, bytewise(v.input)} {
+			tok := &parse.Tokenizer{C1: v.c1}
+			if actual := collect(tok, chunks...); !reflect.DeepEqual(actual, v.expected) {
+				t.Errorf("Input %q: Get %+v, want %+v", v.input, actual, v.expected)
+			}
+		}
+	}
+}
+
+func TestTokenPos(t *testing.T) {
+	type pos struct {
+		Raw string
+		Pos int
+	}
+	var actual []pos
+	tok := &parse.Tokenizer{MaxSequenceLen: 6}
+	for _, chunk := range []string{"ab\x1b[3", "1mc\n\x1b]0;t\x1b[m", "\x1b[1;2;", "3;4mz"} {
+		tok.Feed([]byte(chunk), func(t parse.Token) error {
+			actual = append(actual, pos{string(t.Raw), t.Pos})
+			return nil
+		})
+	}
+	expected := []pos{
+		{"ab", 0},
+		{"\x1b[31m", -3},
+		{"c", 2},
+		{"\n", 3},
+		{"\x1b]0;t", 4},
+		{"\x1b[m", 9},
+		{"\x1b[1;2;", -6},
+		{"3;4m", 0},
+		{"z", 4},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Get %+v, want %+v", actual, expected)
+	}
+}
+
+func TestMaxStringLen(t *testing.T) {
+	input := "\x1bPq123456789\x1b\\a\x1b]0;title\x07b\x1b_1234\x1b\\"
+	expected := []token{
+		{Kind: parse.DCS, Raw: "\x1bPq12345", Final: 'q', Data: "12345", Overflow: true},
+		text("a"),
+		{Kind: parse.OSC, Raw: "\x1b]0;tit", Data: "0;tit", Overflow: true},
+		text("b"),
+		{Kind: parse.APC, Raw: "\x1b_1234\x1b\\", Data: "1234"},
+	}
+	for _, chunks := range [][]string{{input}, bytewise(input)} {
+		tok := &parse.Tokenizer{MaxStringLen: 4}
+		if actual := collect(tok, chunks...); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Get %+v, want %+v", actual, expected)
+		}
+	}
+}
+
+func TestPendingOverflow(t *testing.T) {
+	tok := &parse.Tokenizer{MaxStringLen: 4}
+	collect(tok, "\x1b]0;long title")
+	if actual := tok.Pending(); actual != nil {
+		t.Errorf("Get %q, want nil", actual)
+	}
+}
+
+func TestSequenceLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token
+	}{
+		{"\x1b[1;2;3;4m", []token{{Kind: parse.CSI, Raw: "\x1b[1;2;3;4m", Params: "1;2;3;4", Final: 'm'}}},
+		{"\x1b[1;2;3;4;5ma", []token{
+			{Kind: parse.CSI, Raw: "\x1b[1;2;3;4;5m", Overflow: true},
+			text("a"),
+		}},
+		{"\x1b[38:2:1:2m", []token{
+			{Kind: parse.CSI, Raw: "\x1b[38:2:1:2m", Overflow: true},
+		}},
+		{"\x1b[1111111111 qa", []token{
+			{Kind: parse.CSI, Raw: "\x1b[1111111111 q", Overflow: true},
+			text("a"),
+		}},
+		{"\x1b[1;2;3;4;5\n6\x1b[m", []token{
+			{Kind: parse.CSI, Raw: "\x1b[1;2;3;4;5", Overflow: true},
+			{Kind: parse.Control, Raw: "\n"},
+			{Kind: parse.CSI, Raw: "6", Overflow: true},
+			{Kind: parse.CSI, Raw: "\x1b[m", Final: 'm'},
+		}},
+		{"\x1b[1;2;3;4;5\xffa", []token{
+			{Kind: parse.CSI, Raw: "\x1b[1;2;3;4;5", Overflow: true},
+			text("\xffa"),
+		}},
+		{"\x1b(((((((((Ba", []token{
+			{Kind: parse.Esc, Raw: "\x1b(((((((((B", Overflow: true},
+			text("a"),
+		}},
+		{"\x1bP1;2;3;4;5q#0\x1b\\a", []token{
+			{Kind: parse.DCS, Raw: "\x1bP1;2;3;4", Overflow: true},
+			text("a"),
+		}},
+	}
+	for _, v := range tests {
+		for _, chunks := range [][]string{{v.input}, bytewise(v.input)} {
+			tok := &parse.Tokenizer{MaxSequenceLen: 10, MaxParams: 4}
+			if actual := collect(tok, chunks...); !reflect.DeepEqual(actual, v.expected) {
+				t.Errorf("Input %q: Get %+v, want %+v", v.input, actual, v.expected)
+			}
+		}
+	}
+}
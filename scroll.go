@@ -0,0 +1,184 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+import (
+	"unicode/utf8"
+
+	"github.com/shiena/ansicolor/parse"
+)
+
+const (
+	setScrollRegionCode byte = 'r' // DECSTBM
+	scrollUpCode        byte = 'S' // SU
+	scrollDownCode      byte = 'T' // SD
+
+	lineFeed byte = '\n'
+)
+
+// scrollRegion is the rows of the window, counting from 0, that DECSTBM
+// sets as the scrolling region.
+type scrollRegion struct {
+	top, bottom int
+}
+
+// setScrollRegion sets the scrolling region as DECSTBM does and moves the
+// cursor to the top left of the window. A region of the whole window
+// clears it, and a region without two rows is ignored.
+func (cw *ansiColorWriter) setScrollRegion(param []byte) parseResult {
+	info, err := cw.console.ScreenBufferInfo()
+	if err != nil {
+		return noConsole
+	}
+
+	params := parse.ParseParams(param)
+	win := info.Window
+	height := int(win.Bottom-win.Top) + 1
+	arg := func(i, def int) int {
+		if i < len(params) {
+			if v := params[i].Value(def); v > 0 {
+				return v
+			}
+		}
+		return def
+	}
+	top, bottom := arg(0, 1), arg(1, height)
+	if bottom > height {
+		bottom = height
+	}
+	if top >= bottom {
+		return handled
+	}
+
+	cw.scrollRegion = nil
+	if top > 1 || bottom < height {
+		cw.scrollRegion = &scrollRegion{top - 1, bottom - 1}
+	}
+	cw.console.SetCursorPosition(Coord{win.Left, win.Top})
+	return handled
+}
+
+// scrollRows returns the first and the last rows of the buffer in the
+// scrolling region, which is the whole window if it is not set.
+func (cw *ansiColorWriter) scrollRows(win SmallRect) (top, bottom int16) {
+	top, bottom = win.Top, win.Bottom
+	if r := cw.scrollRegion; r != nil {
+		// the window may have shrunk since the region was set
+		top = int16(clamp(int(win.Top)+r.top, int(win.Top), int(win.Bottom)))
+		bottom = int16(clamp(int(win.Top)+r.bottom, int(top), int(win.Bottom)))
+	}
+	return top, bottom
+}
+
+// scroll moves the text in the scrolling region up n rows, or down -n
+// rows if n is negative, and fills the rows left behind with spaces in the
+// colors of the current attribute.
+func (cw *ansiColorWriter) scroll(info ScreenBufferInfo, n int) {
+	top, bottom := cw.scrollRows(info.Window)
+	height := int(bottom-top) + 1
+	n = clamp(n, -height, height)
+
+	region := SmallRect{0, top, info.Size.X - 1, bottom}
+	dest := Coord{0, top - int16(n)}
+	fill := CharInfo{' ', info.Attributes & (foregroundMask | backgroundMask)}
+	cw.console.ScrollScreenBuffer(region, &region, dest, fill)
+}
+
+// scrollLines scrolls the scrolling region as SU or SD of final does. The
+// cursor does not move.
+func (cw *ansiColorWriter) scrollLines(final byte, param []byte) parseResult {
+	params := parse.ParseParams(param)
+	if len(params) > 1 {
+		// CSI T with more parameters starts mouse highlight tracking
+		return unknown
+	}
+	info, err := cw.console.ScreenBufferInfo()
+	if err != nil {
+		return noConsole
+	}
+
+	n := 1
+	if len(params) == 1 {
+		if v := params[0].Value(1); v > 0 {
+			n = v
+		}
+	}
+	if final == scrollDownCode {
+		n = -n
+	}
+	cw.scroll(info, n)
+	return handled
+}
+
+// lineFeed scrolls the scrolling region up for LF at its bottom margin,
+// where the console would move the cursor out of the region. The cursor
+// returns to the beginning of the line as the console does for LF. Unless
+// a region is set or the cursor is at its bottom margin, it returns
+// unknown and the LF is written as is.
+func (cw *ansiColorWriter) lineFeed() parseResult {
+	if cw.defaultAttr == nil || cw.scrollRegion == nil {
+		return unknown
+	}
+	info, err := cw.console.ScreenBufferInfo()
+	if err != nil {
+		return noConsole
+	}
+	if _, bottom := cw.scrollRows(info.Window); info.CursorPosition.Y != bottom {
+		return unknown
+	}
+
+	cw.scroll(info, 1)
+	cw.console.SetCursorPosition(Coord{0, info.CursorPosition.Y})
+	return handled
+}
+
+// writeText writes the text b, which is at the offset pos in the input of
+// Write, while a scrolling region is set. Text that would wrap at the end
+// of the bottom margin scrolls the region up instead, so the console never
+// moves the cursor below the region.
+func (cw *ansiColorWriter) writeText(b []byte, pos int) error {
+	for len(b) > 0 {
+		info, err := cw.console.ScreenBufferInfo()
+		if err != nil {
+			return cw.write(b, pos)
+		}
+		top, bottom := cw.scrollRows(info.Window)
+		cursor := info.CursorPosition
+		width := int(info.Size.X)
+		// the cells up to the end of the bottom margin
+		room := (int(bottom-cursor.Y)+1)*width - int(cursor.X)
+		if cursor.Y < top || cursor.Y > bottom || utf8.RuneCount(b) < room {
+			return cw.write(b, pos)
+		}
+
+		// the console writes up to the cell before the last one, which
+		// is filled without moving the cursor
+		n := runeOffset(b, room-1)
+		if n > 0 {
+			if err := cw.write(b[:n], pos); err != nil {
+				return err
+			}
+		}
+		r, size := utf8.DecodeRune(b[n:])
+		last := Coord{info.Size.X - 1, bottom}
+		cw.console.FillOutputCharacter(r, 1, last)
+		cw.console.FillOutputAttribute(info.Attributes, 1, last)
+		cw.scroll(info, 1)
+		cw.console.SetCursorPosition(Coord{0, bottom})
+		b, pos = b[n+size:], pos+n+size
+	}
+	return nil
+}
+
+// runeOffset returns the offset of the n-th rune of b, or len(b) if b has
+// fewer runes.
+func runeOffset(b []byte, n int) int {
+	i := 0
+	for ; n > 0 && i < len(b); n-- {
+		_, size := utf8.DecodeRune(b[i:])
+		i += size
+	}
+	return i
+}
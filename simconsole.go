@@ -0,0 +1,246 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+var errOutOfBuffer = errors.New("ansicolor: position is outside the screen buffer")
+
+const tabWidth = 8
+
+// SimConsole is an in-memory Console that models a legacy Windows console
+// screen buffer. Text written to it is placed at the cursor with the current
+// attributes, so a SimConsole can be used as both the io.Writer and the
+// Console of a translating writer.
+type SimConsole struct {
+	size   Coord
+	cursor Coord
+	attr   uint16
+	window SmallRect
+	cells  []CharInfo
+}
+
+// NewSimConsole creates a width x height screen buffer filled with spaces
+// in attr, which also becomes the current text attribute. The window shows
+// the whole buffer.
+func NewSimConsole(width, height int, attr uint16) *SimConsole {
+	c := &SimConsole{
+		size:   Coord{int16(width), int16(height)},
+		attr:   attr,
+		window: SmallRect{0, 0, int16(width) - 1, int16(height) - 1},
+		cells:  make([]CharInfo, width*height),
+	}
+	for i := range c.cells {
+		c.cells[i] = CharInfo{' ', attr}
+	}
+	return c
+}
+
+func (c *SimConsole) inside(pos Coord) bool {
+	return 0 <= pos.X && pos.X < c.size.X && 0 <= pos.Y && pos.Y < c.size.Y
+}
+
+func (c *SimConsole) index(pos Coord) int {
+	return int(pos.Y)*int(c.size.X) + int(pos.X)
+}
+
+// ScreenBufferInfo implements Console.
+func (c *SimConsole) ScreenBufferInfo() (ScreenBufferInfo, error) {
+	return ScreenBufferInfo{
+		Size:              c.size,
+		CursorPosition:    c.cursor,
+		Attributes:        c.attr,
+		Window:            c.window,
+		MaximumWindowSize: c.size,
+	}, nil
+}
+
+// SetTextAttribute implements Console.
+func (c *SimConsole) SetTextAttribute(attr uint16) error {
+	c.attr = attr
+	return nil
+}
+
+// SetWindow sets the part of the buffer shown in the window, whose rows
+// above are the scrollback. Like the console, the window moves up or down
+// to keep the cursor in it.
+func (c *SimConsole) SetWindow(window SmallRect) error {
+	if window.Left > window.Right || window.Top > window.Bottom ||
+		!c.inside(Coord{window.Left, window.Top}) || !c.inside(Coord{window.Right, window.Bottom}) {
+		return errOutOfBuffer
+	}
+	c.window = window
+	c.showCursor()
+	return nil
+}
+
+// showCursor moves the window up or down to show the cursor.
+func (c *SimConsole) showCursor() {
+	shift := int16(0)
+	switch {
+	case c.cursor.Y < c.window.Top:
+		shift = c.cursor.Y - c.window.Top
+	case c.cursor.Y > c.window.Bottom:
+		shift = c.cursor.Y - c.window.Bottom
+	}
+	c.window.Top += shift
+	c.window.Bottom += shift
+}
+
+// SetCursorPosition implements Console.
+func (c *SimConsole) SetCursorPosition(pos Coord) error {
+	if !c.inside(pos) {
+		return errOutOfBuffer
+	}
+	c.cursor = pos
+	c.showCursor()
+	return nil
+}
+
+// FillOutputCharacter implements Console.
+func (c *SimConsole) FillOutputCharacter(ch rune, n int, pos Coord) error {
+	if !c.inside(pos) {
+		return errOutOfBuffer
+	}
+	for i := c.index(pos); n > 0 && i < len(c.cells); i, n = i+1, n-1 {
+		c.cells[i].Char = ch
+	}
+	return nil
+}
+
+// FillOutputAttribute implements Console.
+func (c *SimConsole) FillOutputAttribute(attr uint16, n int, pos Coord) error {
+	if !c.inside(pos) {
+		return errOutOfBuffer
+	}
+	for i := c.index(pos); n > 0 && i < len(c.cells); i, n = i+1, n-1 {
+		c.cells[i].Attributes = attr
+	}
+	return nil
+}
+
+// ScrollScreenBuffer implements Console.
+func (c *SimConsole) ScrollScreenBuffer(scroll SmallRect, clip *SmallRect, dest Coord, fill CharInfo) error {
+	bounds := SmallRect{0, 0, c.size.X - 1, c.size.Y - 1}
+	if clip != nil {
+		bounds = intersectRect(bounds, *clip)
+	}
+	src := make([]CharInfo, len(c.cells))
+	copy(src, c.cells)
+
+	for y := scroll.Top; y <= scroll.Bottom; y++ {
+		for x := scroll.Left; x <= scroll.Right; x++ {
+			if pos := (Coord{x, y}); inRect(bounds, pos) {
+				c.cells[c.index(pos)] = fill
+			}
+		}
+	}
+	for y := scroll.Top; y <= scroll.Bottom; y++ {
+		for x := scroll.Left; x <= scroll.Right; x++ {
+			from := Coord{x, y}
+			to := Coord{dest.X + x - scroll.Left, dest.Y + y - scroll.Top}
+			if c.inside(from) && inRect(bounds, to) {
+				c.cells[c.index(to)] = src[c.index(from)]
+			}
+		}
+	}
+	return nil
+}
+
+func inRect(r SmallRect, pos Coord) bool {
+	return r.Left <= pos.X && pos.X <= r.Right && r.Top <= pos.Y && pos.Y <= r.Bottom
+}
+
+func intersectRect(a, b SmallRect) SmallRect {
+	if b.Left > a.Left {
+		a.Left = b.Left
+	}
+	if b.Top > a.Top {
+		a.Top = b.Top
+	}
+	if b.Right < a.Right {
+		a.Right = b.Right
+	}
+	if b.Bottom < a.Bottom {
+		a.Bottom = b.Bottom
+	}
+	return a
+}
+
+// Write places the text of p at the cursor like the legacy console does
+// with processed output: CR, LF, BS and TAB move the cursor, the cursor
+// wraps at the right edge and the buffer scrolls up at the bottom.
+func (c *SimConsole) Write(p []byte) (int, error) {
+	for s := p; len(s) > 0; {
+		r, size := utf8.DecodeRune(s)
+		s = s[size:]
+		switch r {
+		case '\r':
+			c.cursor.X = 0
+		case '\n':
+			c.cursor.X = 0
+			c.lineFeed()
+		case '\b':
+			if c.cursor.X > 0 {
+				c.cursor.X--
+			}
+		case '\t':
+			c.cursor.X = (c.cursor.X/tabWidth + 1) * tabWidth
+			if c.cursor.X >= c.size.X {
+				c.cursor.X = c.size.X - 1
+			}
+		case '\a':
+		default:
+			c.cells[c.index(c.cursor)] = CharInfo{r, c.attr}
+			c.cursor.X++
+			if c.cursor.X >= c.size.X {
+				c.cursor.X = 0
+				c.lineFeed()
+			}
+		}
+	}
+	c.showCursor()
+	return len(p), nil
+}
+
+func (c *SimConsole) lineFeed() {
+	if c.cursor.Y < c.size.Y-1 {
+		c.cursor.Y++
+		return
+	}
+	width := int(c.size.X)
+	copy(c.cells, c.cells[width:])
+	for i := len(c.cells) - width; i < len(c.cells); i++ {
+		c.cells[i] = CharInfo{' ', c.attr}
+	}
+}
+
+// Cell returns the content of the cell at column x and row y.
+func (c *SimConsole) Cell(x, y int) CharInfo {
+	return c.cells[c.index(Coord{int16(x), int16(y)})]
+}
+
+// Line returns the text of row y without trailing spaces.
+func (c *SimConsole) Line(y int) string {
+	width := int(c.size.X)
+	var b strings.Builder
+	for _, cell := range c.cells[y*width : (y+1)*width] {
+		b.WriteRune(cell.Char)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// String returns the text of the whole buffer, one row per line.
+func (c *SimConsole) String() string {
+	lines := make([]string, c.size.Y)
+	for y := range lines {
+		lines[y] = c.Line(y)
+	}
+	return strings.Join(lines, "\n")
+}
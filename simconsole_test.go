@@ -0,0 +1,55 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/shiena/ansicolor"
+)
+
+func TestSimConsoleWrite(t *testing.T) {
+	console := ansicolor.NewSimConsole(10, 3, simDefaultAttr)
+	fmt.Fprint(console, "abc\r\nwrapped line\n\tx")
+
+	expected := "wrapped li\nne\n        x"
+	if actual := console.String(); actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+	info, _ := console.ScreenBufferInfo()
+	if actual, expected := info.CursorPosition, (ansicolor.Coord{X: 9, Y: 2}); actual != expected {
+		t.Errorf("Get %v, want %v", actual, expected)
+	}
+}
+
+func TestSimConsoleFill(t *testing.T) {
+	console := ansicolor.NewSimConsole(4, 2, simDefaultAttr)
+	console.FillOutputCharacter('x', 3, ansicolor.Coord{X: 2, Y: 0})
+	console.FillOutputAttribute(0x0010, 2, ansicolor.Coord{X: 3, Y: 0})
+
+	if actual, expected := console.String(), "  xx\nx"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+	if actual := console.Cell(0, 1).Attributes; actual != 0x0010 {
+		t.Errorf("Get 0x%04x, want 0x%04x", actual, 0x0010)
+	}
+	if err := console.SetCursorPosition(ansicolor.Coord{X: 4, Y: 0}); err == nil {
+		t.Error("SetCursorPosition outside the buffer should fail")
+	}
+}
+
+func TestSimConsoleScrollScreenBuffer(t *testing.T) {
+	console := ansicolor.NewSimConsole(3, 4, simDefaultAttr)
+	fmt.Fprint(console, "aaabbbcccdd")
+
+	clip := ansicolor.SmallRect{Left: 0, Top: 1, Right: 2, Bottom: 3}
+	console.ScrollScreenBuffer(clip, &clip, ansicolor.Coord{X: 0, Y: 0}, ansicolor.CharInfo{Char: '.', Attributes: simDefaultAttr})
+
+	expected := "aaa\nccc\ndd\n..."
+	if actual := console.String(); actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+import "github.com/shiena/ansicolor/parse"
+
+// DefaultMaxStyleStack is the default number of the styles that XTPUSHSGR
+// can save, which is the same as xterm.
+const DefaultMaxStyleStack = 10
+
+// WithStyleStackLimit limits the number of the styles saved by XTPUSHSGR
+// (CSI # { or CSI # p) to n. When the stack is full, a push drops the
+// oldest style so that the inner pops still work. Zero disables the
+// stack, and a pop without a saved style is ignored.
+func WithStyleStackLimit(n int) Option {
+	return func(cw *ansiColorWriter) {
+		cw.maxStyleStack = n
+	}
+}
+
+// savedStyle is a style saved by XTPUSHSGR and the parts of it that
+// XTPOPSGR restores.
+type savedStyle struct {
+	style      Style
+	base       baseColors
+	all        bool
+	attributes AttributeSet
+	foreground bool
+	background bool
+}
+
+// the parameters of XTPUSHSGR that select the parts to save
+const (
+	pushBold          = 1
+	pushFaint         = 2
+	pushItalic        = 3
+	pushUnderline     = 4
+	pushBlink         = 5
+	pushReverse       = 7
+	pushConceal       = 8
+	pushStrikethrough = 9
+	pushDouble        = 21
+	pushForeground    = 30
+	pushBackground    = 31
+)
+
+func (cw *ansiColorWriter) pushStyle(param []byte) parseResult {
+	if !cw.syncStyle() {
+		return noConsole
+	}
+	if cw.maxStyleStack <= 0 {
+		return handled
+	}
+
+	saved := savedStyle{style: cw.style, base: cw.base}
+	params := parse.ParseParams(param)
+	if len(params) == 0 {
+		saved.all = true
+	}
+	for _, p := range params {
+		switch p.Value(0) {
+		case pushBold:
+			saved.attributes.Set(Bold, true)
+		case pushFaint:
+			saved.attributes.Set(Faint, true)
+		case pushItalic:
+			saved.attributes.Set(Italic, true)
+			saved.attributes.Set(Fraktur, true)
+		case pushUnderline, pushDouble:
+			saved.attributes.Set(Underline, true)
+		case pushBlink:
+			saved.attributes.Set(Blink, true)
+			saved.attributes.Set(RapidBlink, true)
+		case pushReverse:
+			saved.attributes.Set(Reverse, true)
+		case pushConceal:
+			saved.attributes.Set(Conceal, true)
+		case pushStrikethrough:
+			saved.attributes.Set(Strikethrough, true)
+		case pushForeground:
+			saved.foreground = true
+		case pushBackground:
+			saved.background = true
+		}
+	}
+
+	if len(cw.styleStack) >= cw.maxStyleStack {
+		copy(cw.styleStack, cw.styleStack[1:])
+		cw.styleStack = cw.styleStack[:len(cw.styleStack)-1]
+	}
+	cw.styleStack = append(cw.styleStack, saved)
+	return handled
+}
+
+func (cw *ansiColorWriter) popStyle() parseResult {
+	if !cw.syncStyle() {
+		return noConsole
+	}
+	if len(cw.styleStack) == 0 {
+		return handled
+	}
+	saved := cw.styleStack[len(cw.styleStack)-1]
+	cw.styleStack = cw.styleStack[:len(cw.styleStack)-1]
+
+	if saved.all {
+		cw.style, cw.base = saved.style, saved.base
+		cw.showStyle()
+		return handled
+	}
+	for a := Attribute(0); a < attributeCount; a++ {
+		if saved.attributes.Has(a) {
+			cw.style.Attributes.Set(a, saved.style.Attributes.Has(a))
+		}
+	}
+	if saved.attributes.Has(Bold) {
+		cw.base.foregroundIntensity = saved.base.foregroundIntensity
+	}
+	if saved.attributes.Has(Blink) {
+		cw.base.backgroundIntensity = saved.base.backgroundIntensity
+	}
+	if saved.foreground {
+		cw.style.Foreground = saved.style.Foreground
+		cw.base.foreground, cw.base.hasForeground = saved.base.foreground, saved.base.hasForeground
+	}
+	if saved.background {
+		cw.style.Background = saved.style.Background
+		cw.base.background, cw.base.hasBackground = saved.base.background, saved.base.hasBackground
+	}
+	cw.showStyle()
+	return handled
+}
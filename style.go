@@ -0,0 +1,261 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+import (
+	"strconv"
+
+	"github.com/shiena/ansicolor/parse"
+)
+
+// ColorKind is the kind of a Color.
+type ColorKind uint8
+
+const (
+	// ColorDefault is the default color of the terminal.
+	ColorDefault ColorKind = iota
+	// ColorBasic is one of the 16 colors of SGR 30 to 37 and 90 to 97
+	// given by Index 0 to 15.
+	ColorBasic
+	// ColorIndexed is one of the 256 colors given by Index.
+	ColorIndexed
+	// ColorRGB is the 24-bit color given by R, G and B.
+	ColorRGB
+)
+
+// Color is a color of a Style. The zero value is the default color.
+type Color struct {
+	Kind    ColorKind
+	Index   uint8
+	R, G, B uint8
+}
+
+// BasicColor returns the color index of the 16 colors, where 0 to 7 are
+// black, red, green, yellow, blue, magenta, cyan and white, and 8 to 15
+// are their bright colors.
+func BasicColor(index uint8) Color {
+	return Color{Kind: ColorBasic, Index: index & 0xf}
+}
+
+// IndexedColor returns the color index of the 256 colors.
+func IndexedColor(index uint8) Color {
+	return Color{Kind: ColorIndexed, Index: index}
+}
+
+// RGBColor returns the 24-bit color of r, g and b.
+func RGBColor(r, g, b uint8) Color {
+	return Color{Kind: ColorRGB, R: r, G: g, B: b}
+}
+
+// Style is the state of the text style set by SGR sequences. The zero
+// value is the default style, which SGR 0 restores.
+type Style struct {
+	Foreground Color
+	Background Color
+	// UnderlineColor has no SGR codes for the basic colors, which are
+	// written as the same colors of the 256 colors and read back by Apply
+	// as IndexedColor.
+	UnderlineColor Color
+	Attributes     AttributeSet
+}
+
+// sgrOn and sgrOff are the SGR codes that turn each attribute on and off.
+// An off code may turn off other attributes as well.
+var (
+	sgrOn = [attributeCount]int{
+		Bold:          ansiIntensityOn,
+		Faint:         ansiFaintOn,
+		Italic:        ansiItalicOn,
+		Blink:         ansiBlinkOn,
+		RapidBlink:    ansiRapidBlinkOn,
+		Strikethrough: ansiStrikethroughOn,
+		Fraktur:       ansiFrakturOn,
+		Framed:        ansiFramedOn,
+		Encircled:     ansiEncircledOn,
+		Overline:      ansiOverlineOn,
+		Underline:     ansiUnderlineOn,
+		Reverse:       ansiReverseOn,
+		Conceal:       ansiConcealOn,
+	}
+	sgrOff = [attributeCount]int{
+		Bold:          ansiNormalIntensity,
+		Faint:         ansiNormalIntensity,
+		Italic:        ansiItalicOff,
+		Blink:         ansiBlinkOff,
+		RapidBlink:    ansiBlinkOff,
+		Strikethrough: ansiStrikethroughOff,
+		Fraktur:       ansiItalicOff,
+		Framed:        ansiFramedOff,
+		Encircled:     ansiFramedOff,
+		Overline:      ansiOverlineOff,
+		Underline:     ansiUnderlineOff,
+		Reverse:       ansiReverseOff,
+		Conceal:       ansiConcealOff,
+	}
+)
+
+// Apply folds the parameters of an SGR sequence, such as "1;31" of
+// ESC [ 1 ; 3 1 m, into s. Empty params resets s. As it always has in
+// this package, 21 turns off Bold instead of turning on the double
+// underline. The codes that a Style does not hold, such as the fonts, and
+// unknown codes are ignored.
+func (s *Style) Apply(params []byte) {
+	ps := parse.ParseParams(params)
+	if len(ps) == 0 {
+		ps = []parse.Param{{ansiReset}}
+	}
+	for i := 0; i < len(ps); {
+		i += s.apply(ps[i:])
+	}
+}
+
+// apply folds the first SGR parameter of params into s and returns the
+// number of the parameters that it takes.
+func (s *Style) apply(params []parse.Param) int {
+	p := params[0]
+	code := p.Value(ansiReset)
+	switch {
+	case code == ansiExtendedForeground || code == ansiExtendedBackground || code == ansiUnderlineColor:
+		c, n, ok := extendedColor(params)
+		switch {
+		case !ok:
+			// unsupported color
+		case code == ansiExtendedForeground:
+			s.Foreground = c
+		case code == ansiExtendedBackground:
+			s.Background = c
+		default:
+			s.UnderlineColor = c
+		}
+		return n
+	case len(p) > 1:
+		// colon separated sub-parameters
+		if code == ansiUnderlineOn {
+			// 4:0 is no underline and 4:1 to 4:5 are the underline styles
+			s.Attributes.Set(Underline, p.Sub(1, 1) != 0)
+		}
+	case code == ansiReset:
+		*s = Style{}
+	case ansiForegroundBlack <= code && code <= ansiForegroundWhite:
+		s.Foreground = BasicColor(uint8(code - ansiForegroundBlack))
+	case ansiLightForegroundGray <= code && code <= ansiLightForegroundWhite:
+		s.Foreground = BasicColor(uint8(code - ansiLightForegroundGray + 8))
+	case ansiBackgroundBlack <= code && code <= ansiBackgroundWhite:
+		s.Background = BasicColor(uint8(code - ansiBackgroundBlack))
+	case ansiLightBackgroundGray <= code && code <= ansiLightBackgroundWhite:
+		s.Background = BasicColor(uint8(code - ansiLightBackgroundGray + 8))
+	case code == ansiForegroundDefault:
+		s.Foreground = Color{}
+	case code == ansiBackgroundDefault:
+		s.Background = Color{}
+	case code == ansiUnderlineColorDefault:
+		s.UnderlineColor = Color{}
+	case code == ansiIntensityOff:
+		s.Attributes.Set(Bold, false)
+	default:
+		for a := Attribute(0); a < attributeCount; a++ {
+			switch code {
+			case sgrOn[a]:
+				s.Attributes.Set(a, true)
+			case sgrOff[a]:
+				s.Attributes.Set(a, false)
+			}
+		}
+	}
+	return 1
+}
+
+// SGR returns the SGR sequence that sets s regardless of the current
+// style.
+func (s Style) SGR() string {
+	params := []int{ansiReset}
+	for a := Attribute(0); a < attributeCount; a++ {
+		if s.Attributes.Has(a) {
+			params = append(params, sgrOn[a])
+		}
+	}
+	for i, c := range []Color{s.Foreground, s.Background, s.UnderlineColor} {
+		if c.Kind != ColorDefault {
+			params = appendColor(params, c, colorBases[i])
+		}
+	}
+	return sgrSequence(params)
+}
+
+// Diff returns the shortest SGR sequence that it finds to change the
+// style from into the style to, or "" if they are the same.
+func Diff(from, to Style) string {
+	if from == to {
+		return ""
+	}
+	var params []int
+
+	// an off code may turn off more attributes than needed, and those
+	// are turned on again
+	on := from.Attributes
+	for a := Attribute(0); a < attributeCount; a++ {
+		if !on.Has(a) || to.Attributes.Has(a) {
+			// off already or kept
+			continue
+		}
+		params = append(params, sgrOff[a])
+		for b := Attribute(0); b < attributeCount; b++ {
+			if sgrOff[b] == sgrOff[a] {
+				on.Set(b, false)
+			}
+		}
+	}
+	for a := Attribute(0); a < attributeCount; a++ {
+		if to.Attributes.Has(a) && !on.Has(a) {
+			params = append(params, sgrOn[a])
+		}
+	}
+
+	fromColors := []Color{from.Foreground, from.Background, from.UnderlineColor}
+	for i, c := range []Color{to.Foreground, to.Background, to.UnderlineColor} {
+		if c != fromColors[i] {
+			params = appendColor(params, c, colorBases[i])
+		}
+	}
+
+	if seq, reset := sgrSequence(params), to.SGR(); len(reset) < len(seq) {
+		return reset
+	}
+	return sgrSequence(params)
+}
+
+// colorBases are the base codes of the foreground, the background and the
+// underline colors, to which 8 is added for an extended color and 9 for
+// the default color.
+var colorBases = [...]int{ansiForegroundBlack, ansiBackgroundBlack, ansiUnderlineColor - 8}
+
+// appendColor appends to params the SGR parameters that set c as the color
+// of base. The underline color has no codes for the basic colors and
+// takes them as the 256 colors.
+func appendColor(params []int, c Color, base int) []int {
+	switch {
+	case c.Kind == ColorDefault:
+		return append(params, base+9)
+	case c.Kind == ColorBasic && base != colorBases[2]:
+		if c.Index < 8 {
+			return append(params, base+int(c.Index))
+		}
+		return append(params, base+60+int(c.Index)-8)
+	case c.Kind == ColorRGB:
+		return append(params, base+8, extendedColorRGB, int(c.R), int(c.G), int(c.B))
+	}
+	return append(params, base+8, extendedColor256, int(c.Index))
+}
+
+func sgrSequence(params []int) string {
+	b := []byte("\x1b[")
+	for i, p := range params {
+		if i > 0 {
+			b = append(b, ';')
+		}
+		b = strconv.AppendInt(b, int64(p), 10)
+	}
+	return string(append(b, sgrCode))
+}
@@ -0,0 +1,159 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shiena/ansicolor"
+)
+
+func attributes(attrs ...ansicolor.Attribute) ansicolor.AttributeSet {
+	var s ansicolor.AttributeSet
+	for _, a := range attrs {
+		s.Set(a, true)
+	}
+	return s
+}
+
+func TestStyleApply(t *testing.T) {
+	tests := []struct {
+		params   []string
+		expected ansicolor.Style
+	}{
+		{[]string{""}, ansicolor.Style{}},
+		{[]string{"1;31"}, ansicolor.Style{
+			Foreground: ansicolor.BasicColor(1),
+			Attributes: attributes(ansicolor.Bold),
+		}},
+		{[]string{"97;104"}, ansicolor.Style{
+			Foreground: ansicolor.BasicColor(15),
+			Background: ansicolor.BasicColor(12),
+		}},
+		{[]string{"38;5;208;48:2::1:2:3;58;2;4;5;6"}, ansicolor.Style{
+			Foreground:     ansicolor.IndexedColor(208),
+			Background:     ansicolor.RGBColor(1, 2, 3),
+			UnderlineColor: ansicolor.RGBColor(4, 5, 6),
+		}},
+		{[]string{"38;5;208", "39;59"}, ansicolor.Style{}},
+		{[]string{"1;2;3;4;5;6;7;8;9;20;51;52;53"}, ansicolor.Style{
+			Attributes: attributes(ansicolor.Bold, ansicolor.Faint, ansicolor.Italic,
+				ansicolor.Underline, ansicolor.Blink, ansicolor.RapidBlink, ansicolor.Reverse,
+				ansicolor.Conceal, ansicolor.Strikethrough, ansicolor.Fraktur, ansicolor.Framed,
+				ansicolor.Encircled, ansicolor.Overline),
+		}},
+		{[]string{"1;2;3;4;5;6;7;8;9;20;51;52;53", "22;23;24;25;27;28;29;54;55"}, ansicolor.Style{}},
+		{[]string{"1;2;21"}, ansicolor.Style{Attributes: attributes(ansicolor.Faint)}},
+		{[]string{"4:3", "4:0"}, ansicolor.Style{}},
+		{[]string{"4:3;10;26;60;73"}, ansicolor.Style{Attributes: attributes(ansicolor.Underline)}},
+		{[]string{"1;31;44", "0"}, ansicolor.Style{}},
+	}
+	for _, v := range tests {
+		var s ansicolor.Style
+		for _, p := range v.params {
+			s.Apply([]byte(p))
+		}
+		if s != v.expected {
+			t.Errorf("Params %q: Get %+v, want %+v", v.params, s, v.expected)
+		}
+	}
+}
+
+func TestStyleSGR(t *testing.T) {
+	tests := []struct {
+		style    ansicolor.Style
+		expected string
+	}{
+		{ansicolor.Style{}, "\x1b[0m"},
+		{ansicolor.Style{
+			Foreground: ansicolor.BasicColor(1),
+			Background: ansicolor.BasicColor(12),
+			Attributes: attributes(ansicolor.Bold, ansicolor.Underline),
+		}, "\x1b[0;1;4;31;104m"},
+		{ansicolor.Style{
+			Foreground:     ansicolor.IndexedColor(208),
+			Background:     ansicolor.RGBColor(1, 2, 3),
+			UnderlineColor: ansicolor.IndexedColor(9),
+		}, "\x1b[0;38;5;208;48;2;1;2;3;58;5;9m"},
+		{ansicolor.Style{UnderlineColor: ansicolor.BasicColor(9)}, "\x1b[0;58;5;9m"},
+	}
+	for _, v := range tests {
+		if actual := v.style.SGR(); actual != v.expected {
+			t.Errorf("Get %q, want %q", actual, v.expected)
+		}
+	}
+}
+
+func TestStyleDiff(t *testing.T) {
+	bold := ansicolor.Style{Attributes: attributes(ansicolor.Bold)}
+	boldFaint := ansicolor.Style{Attributes: attributes(ansicolor.Bold, ansicolor.Faint)}
+	faint := ansicolor.Style{Attributes: attributes(ansicolor.Faint)}
+	red := ansicolor.Style{Foreground: ansicolor.BasicColor(1)}
+	boldRed := ansicolor.Style{Foreground: ansicolor.BasicColor(1), Attributes: attributes(ansicolor.Bold)}
+	boldFaintRed := ansicolor.Style{Foreground: ansicolor.BasicColor(1), Attributes: attributes(ansicolor.Bold, ansicolor.Faint)}
+	faintRed := ansicolor.Style{Foreground: ansicolor.BasicColor(1), Attributes: attributes(ansicolor.Faint)}
+	many := ansicolor.Style{
+		Foreground: ansicolor.RGBColor(1, 2, 3),
+		Background: ansicolor.IndexedColor(100),
+		Attributes: attributes(ansicolor.Italic, ansicolor.Underline, ansicolor.Reverse),
+	}
+	underlined := ansicolor.Style{
+		UnderlineColor: ansicolor.IndexedColor(9),
+		Attributes:     attributes(ansicolor.Underline),
+	}
+	tests := []struct {
+		from, to ansicolor.Style
+		expected string
+	}{
+		{red, red, ""},
+		{ansicolor.Style{}, red, "\x1b[31m"},
+		{red, ansicolor.Style{}, "\x1b[0m"},
+		{red, boldRed, "\x1b[1m"},
+		{boldRed, red, "\x1b[22m"},
+		{bold, red, "\x1b[0;31m"},
+		{boldFaintRed, faintRed, "\x1b[22;2m"},
+		{boldFaint, faint, "\x1b[0;2m"},
+		{many, ansicolor.Style{}, "\x1b[0m"},
+		{many, red, "\x1b[0;31m"},
+		{underlined, many, "\x1b[3;7;38;2;1;2;3;48;5;100;59m"},
+		{red, ansicolor.Style{Foreground: ansicolor.BasicColor(1), UnderlineColor: ansicolor.BasicColor(9)}, "\x1b[58;5;9m"},
+		{underlined, ansicolor.Style{UnderlineColor: ansicolor.BasicColor(9), Attributes: attributes(ansicolor.Underline)}, "\x1b[58;5;9m"},
+	}
+	for _, v := range tests {
+		if actual := ansicolor.Diff(v.from, v.to); actual != v.expected {
+			t.Errorf("Diff(%+v, %+v): Get %q, want %q", v.from, v.to, actual, v.expected)
+		}
+	}
+
+	// the difference and the full sequence lead to the same style
+	styles := []ansicolor.Style{{}, bold, boldFaint, faint, red, boldRed, boldFaintRed, faintRed, many, underlined}
+	for _, from := range styles {
+		for _, to := range styles {
+			s := from
+			s.Apply(sgrParams(ansicolor.Diff(from, to)))
+			if from != to && s != to {
+				t.Errorf("Diff(%+v, %+v): Get %+v", from, to, s)
+			}
+			s = from
+			s.Apply(sgrParams(to.SGR()))
+			if s != to {
+				t.Errorf("SGR(%+v): Get %+v", to, s)
+			}
+		}
+	}
+
+	// a basic underline color is read back as the same indexed color
+	var s ansicolor.Style
+	s.Apply(sgrParams(ansicolor.Style{UnderlineColor: ansicolor.BasicColor(9)}.SGR()))
+	if s.UnderlineColor != ansicolor.IndexedColor(9) {
+		t.Errorf("Get %+v, want %+v", s.UnderlineColor, ansicolor.IndexedColor(9))
+	}
+}
+
+// sgrParams returns the parameters of the SGR sequence seq.
+func sgrParams(seq string) []byte {
+	return []byte(strings.TrimSuffix(strings.TrimPrefix(seq, "\x1b["), "m"))
+}
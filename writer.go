@@ -0,0 +1,523 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor
+
+import (
+	"io"
+
+	"github.com/shiena/ansicolor/parse"
+)
+
+type parseResult int
+
+const (
+	noConsole parseResult = iota
+	handled
+	unknown
+)
+
+type ansiColorWriter struct {
+	w           io.Writer
+	mode        outputMode
+	console     Console
+	defaultAttr *textAttributes
+	tokenizer   parse.Tokenizer
+	oscHandler  OSCHandler
+	quantizer   quantizer
+	renderings  [attributeCount]Rendering
+	palette     Palette
+	fallback    Fallback
+
+	// the style of the text, whether it is set, and the console attribute
+	// that shows it
+	style    Style
+	styled   bool
+	lastAttr uint16
+	base     baseColors
+
+	// the styles saved by XTPUSHSGR
+	styleStack    []savedStyle
+	maxStyleStack int
+
+	// the cursor saved by DECSC or SCOSC
+	savedCursor *savedCursor
+
+	// the scrolling region set by DECSTBM, or nil for the whole window
+	scrollRegion *scrollRegion
+
+	// the input of Write, and the run of it from textStart to textEnd that
+	// is not written yet
+	input              []byte
+	textStart, textEnd int
+
+	// the offset in the input of Write of the last bytes given to w, and
+	// how many of them were written
+	lastPos     int
+	lastWritten int
+}
+
+const (
+	sgrCode byte = 'm'
+
+	// XTPUSHSGR and XTPOPSGR with the intermediate '#', and their aliases
+	pushSGRCode      byte = '{'
+	popSGRCode       byte = '}'
+	pushSGRAliasCode byte = 'p'
+	popSGRAliasCode  byte = 'q'
+)
+
+const (
+	foregroundBlue      = uint16(0x0001)
+	foregroundGreen     = uint16(0x0002)
+	foregroundRed       = uint16(0x0004)
+	foregroundIntensity = uint16(0x0008)
+	backgroundBlue      = uint16(0x0010)
+	backgroundGreen     = uint16(0x0020)
+	backgroundRed       = uint16(0x0040)
+	backgroundIntensity = uint16(0x0080)
+	underscore          = uint16(0x8000)
+
+	foregroundMask = foregroundBlue | foregroundGreen | foregroundRed | foregroundIntensity
+	backgroundMask = backgroundBlue | backgroundGreen | backgroundRed | backgroundIntensity
+)
+
+const (
+	ansiReset            = 0
+	ansiIntensityOn      = 1
+	ansiFaintOn          = 2
+	ansiItalicOn         = 3
+	ansiUnderlineOn      = 4
+	ansiBlinkOn          = 5
+	ansiRapidBlinkOn     = 6
+	ansiReverseOn        = 7
+	ansiConcealOn        = 8
+	ansiStrikethroughOn  = 9
+	ansiFrakturOn        = 20
+	ansiIntensityOff     = 21
+	ansiNormalIntensity  = 22
+	ansiItalicOff        = 23
+	ansiUnderlineOff     = 24
+	ansiBlinkOff         = 25
+	ansiReverseOff       = 27
+	ansiConcealOff       = 28
+	ansiStrikethroughOff = 29
+	ansiFramedOn         = 51
+	ansiEncircledOn      = 52
+	ansiOverlineOn       = 53
+	ansiFramedOff        = 54
+	ansiOverlineOff      = 55
+	ansiUnderlineColor   = 58
+
+	ansiUnderlineColorDefault = 59
+
+	ansiExtendedForeground = 38
+	ansiExtendedBackground = 48
+
+	ansiForegroundBlack   = 30
+	ansiForegroundRed     = 31
+	ansiForegroundGreen   = 32
+	ansiForegroundYellow  = 33
+	ansiForegroundBlue    = 34
+	ansiForegroundMagenta = 35
+	ansiForegroundCyan    = 36
+	ansiForegroundWhite   = 37
+	ansiForegroundDefault = 39
+
+	ansiBackgroundBlack   = 40
+	ansiBackgroundRed     = 41
+	ansiBackgroundGreen   = 42
+	ansiBackgroundYellow  = 43
+	ansiBackgroundBlue    = 44
+	ansiBackgroundMagenta = 45
+	ansiBackgroundCyan    = 46
+	ansiBackgroundWhite   = 47
+	ansiBackgroundDefault = 49
+
+	ansiLightForegroundGray    = 90
+	ansiLightForegroundRed     = 91
+	ansiLightForegroundGreen   = 92
+	ansiLightForegroundYellow  = 93
+	ansiLightForegroundBlue    = 94
+	ansiLightForegroundMagenta = 95
+	ansiLightForegroundCyan    = 96
+	ansiLightForegroundWhite   = 97
+
+	ansiLightBackgroundGray    = 100
+	ansiLightBackgroundRed     = 101
+	ansiLightBackgroundGreen   = 102
+	ansiLightBackgroundYellow  = 103
+	ansiLightBackgroundBlue    = 104
+	ansiLightBackgroundMagenta = 105
+	ansiLightBackgroundCyan    = 106
+	ansiLightBackgroundWhite   = 107
+)
+
+type textAttributes struct {
+	foregroundColor     uint16
+	backgroundColor     uint16
+	foregroundIntensity uint16
+	backgroundIntensity uint16
+	underscore          uint16
+	otherAttributes     uint16
+}
+
+func convertTextAttr(winAttr uint16) *textAttributes {
+	fgColor := winAttr & (foregroundRed | foregroundGreen | foregroundBlue)
+	bgColor := winAttr & (backgroundRed | backgroundGreen | backgroundBlue)
+	fgIntensity := winAttr & foregroundIntensity
+	bgIntensity := winAttr & backgroundIntensity
+	underline := winAttr & underscore
+	otherAttributes := winAttr &^ (foregroundMask | backgroundMask | underscore)
+	return &textAttributes{
+		foregroundColor:     fgColor,
+		backgroundColor:     bgColor,
+		foregroundIntensity: fgIntensity,
+		backgroundIntensity: bgIntensity,
+		underscore:          underline,
+		otherAttributes:     otherAttributes,
+	}
+}
+
+func convertWinAttr(textAttr *textAttributes) uint16 {
+	var winAttr uint16
+	winAttr |= textAttr.foregroundColor
+	winAttr |= textAttr.backgroundColor
+	winAttr |= textAttr.foregroundIntensity
+	winAttr |= textAttr.backgroundIntensity
+	winAttr |= textAttr.underscore
+	winAttr |= textAttr.otherAttributes
+	return winAttr
+}
+
+// baseColors are the 4-bit colors and the other attributes that the
+// console had before the style of the writer was set, which stay until
+// SGR changes them.
+type baseColors struct {
+	foreground, background                   uint16
+	foregroundIntensity, backgroundIntensity uint16
+	other                                    uint16
+	hasForeground, hasBackground             bool
+}
+
+// readStyle takes the style and the base colors from the console
+// attribute winAttr.
+func (cw *ansiColorWriter) readStyle(winAttr uint16) {
+	attr := convertTextAttr(winAttr)
+	cw.style = Style{}
+	cw.style.Attributes.Set(Underline, attr.underscore != 0)
+	cw.base = baseColors{
+		foreground:          attr.foregroundColor,
+		background:          attr.backgroundColor >> 4,
+		foregroundIntensity: attr.foregroundIntensity,
+		backgroundIntensity: attr.backgroundIntensity >> 4,
+		other:               attr.otherAttributes,
+		hasForeground:       true,
+		hasBackground:       true,
+	}
+}
+
+// update drops the base colors that the SGR code has changed from the
+// style from into the style to.
+func (b *baseColors) update(code int, from, to *Style) {
+	switch code {
+	case ansiReset:
+		*b = baseColors{}
+		return
+	case ansiIntensityOff, ansiNormalIntensity:
+		b.foregroundIntensity = 0
+	case ansiBlinkOff:
+		b.backgroundIntensity = 0
+	}
+	if code == ansiForegroundDefault || from.Foreground != to.Foreground {
+		b.hasForeground = false
+		if code == ansiExtendedForeground {
+			b.foregroundIntensity = 0
+		}
+	}
+	if code == ansiBackgroundDefault || from.Background != to.Background {
+		b.hasBackground = false
+		if code == ansiExtendedBackground {
+			b.backgroundIntensity = 0
+		}
+	}
+}
+
+// syncStyle reads the style from the console unless the console still
+// shows the style of the writer. It returns false if the console cannot
+// be read.
+func (cw *ansiColorWriter) syncStyle() bool {
+	screenInfo, err := cw.console.ScreenBufferInfo()
+	if err != nil {
+		return false
+	}
+	if !cw.styled || screenInfo.Attributes != cw.lastAttr {
+		// the console shows the attribute that someone else has set
+		cw.readStyle(screenInfo.Attributes)
+	}
+	return true
+}
+
+// showStyle sets the console attribute that shows the style of the writer.
+func (cw *ansiColorWriter) showStyle() {
+	winTextAttribute := cw.render()
+	cw.console.SetTextAttribute(winTextAttribute)
+	cw.styled, cw.lastAttr = true, winTextAttribute
+}
+
+func (cw *ansiColorWriter) changeColor(param []byte) parseResult {
+	if !cw.syncStyle() {
+		return noConsole
+	}
+
+	csiParam := parse.ParseParams(param)
+	if len(csiParam) == 0 {
+		csiParam = []parse.Param{{ansiReset}}
+	}
+	for i := 0; i < len(csiParam); {
+		from := cw.style
+		code := csiParam[i].Value(ansiReset)
+		i += cw.style.apply(csiParam[i:])
+		cw.base.update(code, &from, &cw.style)
+	}
+	cw.showStyle()
+
+	return handled
+}
+
+// isPrivate reports whether the parameters of a control sequence start
+// with a private marker such as '?'.
+func isPrivate(param []byte) bool {
+	return len(param) > 0 && param[0] >= '<'
+}
+
+func (cw *ansiColorWriter) parseEscapeSequence(tok parse.Token) parseResult {
+	if cw.defaultAttr == nil {
+		return noConsole
+	}
+	switch {
+	case isPrivate(tok.Params):
+		return unknown
+	case string(tok.Intermediates) == "#":
+		switch tok.Final {
+		case pushSGRCode, pushSGRAliasCode:
+			return cw.pushStyle(tok.Params)
+		case popSGRCode, popSGRAliasCode:
+			return cw.popStyle()
+		}
+		return unknown
+	case len(tok.Intermediates) > 0:
+		return unknown
+	}
+
+	switch tok.Final {
+	case sgrCode:
+		return cw.changeColor(tok.Params)
+	case cursorUpCode, cursorDownCode, cursorForwardCode, cursorBackCode,
+		cursorNextLineCode, cursorPrevLineCode, cursorColumnCode, cursorRowCode,
+		cursorPositionCode, cursorHVPositionCode:
+		return cw.moveCursor(tok.Final, tok.Params)
+	case eraseDisplayCode, eraseLineCode:
+		return cw.erase(tok.Final, tok.Params)
+	case saveCursorCode, restoreCursorCode:
+		if len(tok.Params) > 0 {
+			return unknown
+		}
+		if tok.Final == saveCursorCode {
+			return cw.saveCursor()
+		}
+		return cw.restoreCursor()
+	case setScrollRegionCode:
+		return cw.setScrollRegion(tok.Params)
+	case scrollUpCode, scrollDownCode:
+		return cw.scrollLines(tok.Final, tok.Params)
+	default:
+		return unknown
+	}
+}
+
+// parseEscSequence handles an escape sequence other than a control
+// sequence or a string.
+func (cw *ansiColorWriter) parseEscSequence(tok parse.Token) parseResult {
+	if cw.defaultAttr == nil {
+		return noConsole
+	}
+	if len(tok.Intermediates) > 0 {
+		return unknown
+	}
+	switch tok.Final {
+	case decSaveCursorCode:
+		return cw.saveCursor()
+	case decRestoreCursorCode:
+		return cw.restoreCursor()
+	default:
+		return unknown
+	}
+}
+
+func (cw *ansiColorWriter) handleToken(tok parse.Token) error {
+	switch {
+	case tok.Aborted && (cw.mode == DiscardNonColorEscSeq || cw.console == nil):
+		// a cancelled sequence is dropped like an unknown one, and
+		// stripped with FallbackStrip
+		return nil
+	case tok.Kind == parse.Text && cw.scrollRegion == nil:
+		return cw.queue(tok.Raw, tok.Pos)
+	case tok.Kind == parse.Control && (tok.Raw[0] != lineFeed || cw.scrollRegion == nil):
+		return cw.queue(tok.Raw, tok.Pos)
+	}
+	// the text before tok is written first, as tok may change the console
+	if err := cw.flushText(); err != nil {
+		return err
+	}
+
+	if cw.console == nil {
+		// FallbackStrip, as Write passes everything through otherwise
+		if tok.Kind == parse.OSC && !tok.Overflow && cw.oscHandler != nil {
+			cw.oscHandler(tok.Data)
+		}
+		return nil
+	}
+	if tok.Overflow {
+		// an abandoned sequence is output as is, piece by piece, only in
+		// OutputNonColorEscSeq mode. The beginning of a string is never
+		// output, or the console would wait for the rest.
+		if cw.mode == DiscardNonColorEscSeq || (tok.Kind != parse.CSI && tok.Kind != parse.Esc) {
+			return nil
+		}
+		return cw.queue(tok.Raw, tok.Pos)
+	}
+
+	switch tok.Kind {
+	case parse.Text:
+		return cw.writeText(tok.Raw, tok.Pos)
+	case parse.Control:
+		if cw.lineFeed() == handled {
+			return nil
+		}
+	case parse.CSI, parse.Esc:
+		var result parseResult
+		if tok.Kind == parse.CSI {
+			result = cw.parseEscapeSequence(tok)
+		} else {
+			result = cw.parseEscSequence(tok)
+		}
+		if result != noConsole && (cw.mode == DiscardNonColorEscSeq || result != unknown) {
+			return nil
+		}
+	case parse.OSC:
+		if cw.oscHandler != nil {
+			cw.oscHandler(tok.Data)
+			return nil
+		}
+		if cw.mode == DiscardNonColorEscSeq {
+			return nil
+		}
+	case parse.DCS, parse.SOS, parse.PM, parse.APC:
+		if cw.mode == DiscardNonColorEscSeq {
+			return nil
+		}
+	}
+	return cw.queue(tok.Raw, tok.Pos)
+}
+
+// queue writes b, which is at the offset pos in the input of Write, to w.
+// The bytes of the input are kept until the end of the run of them, so
+// that a text with controls such as CR and LF is written at once.
+func (cw *ansiColorWriter) queue(b []byte, pos int) error {
+	if len(b) == 0 || pos < 0 || pos+len(b) > len(cw.input) || &cw.input[pos] != &b[0] {
+		// b is a sequence kept by the tokenizer
+		if err := cw.flushText(); err != nil {
+			return err
+		}
+		return cw.write(b, pos)
+	}
+	if cw.textEnd != pos {
+		if err := cw.flushText(); err != nil {
+			return err
+		}
+		cw.textStart = pos
+	}
+	cw.textEnd = pos + len(b)
+	return nil
+}
+
+// flushText writes the bytes of the input kept by queue.
+func (cw *ansiColorWriter) flushText() error {
+	if cw.textStart == cw.textEnd {
+		return nil
+	}
+	start, end := cw.textStart, cw.textEnd
+	cw.textStart, cw.textEnd = 0, 0
+	return cw.write(cw.input[start:end], start)
+}
+
+// write writes b, which is at the offset pos in the input of Write, to w
+// and reports a short write as io.ErrShortWrite.
+func (cw *ansiColorWriter) write(b []byte, pos int) error {
+	n, err := cw.w.Write(b)
+	cw.lastPos, cw.lastWritten = pos, n
+	if err == nil && n < len(b) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// Write writes p to w after translating the escape sequences.
+// It returns len(p) and nil if the whole of p is handled, where an escape
+// sequence, even an incomplete one at the end of p, counts as handled.
+// Otherwise it returns an error and the number of bytes of p handled
+// before the failure, including the part of a text that w has written.
+func (cw *ansiColorWriter) Write(p []byte) (int, error) {
+	if cw.console == nil && cw.fallback == FallbackPassThrough {
+		n, err := cw.w.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
+		return n, err
+	}
+
+	cw.input = p
+	n, err := cw.tokenizer.Feed(p, cw.handleToken)
+	if err == nil {
+		err = cw.flushText()
+	}
+	cw.input, cw.textStart, cw.textEnd = nil, 0, 0
+	if err != nil && cw.lastPos <= n {
+		// the failed write starts at lastPos, which is before p if it is
+		// negative. A failed write after n is of a control in a sequence
+		// that the tokenizer has discarded from n.
+		if n = cw.lastPos + cw.lastWritten; n < 0 {
+			n = 0
+		}
+	}
+	return n, err
+}
+
+// Flush outputs the incomplete escape sequence at the end of the text
+// written so far in OutputNonColorEscSeq mode, and discards it in
+// DiscardNonColorEscSeq mode or with FallbackStrip.
+func (cw *ansiColorWriter) Flush() error {
+	// the tokenizer may be in a sequence with nothing pending, when it
+	// skips the rest of an over-long one
+	pending := cw.tokenizer.Pending()
+	var err error
+	if len(pending) > 0 && cw.mode != DiscardNonColorEscSeq && cw.console != nil {
+		err = cw.write(pending, 0)
+	}
+	cw.tokenizer.Reset()
+	return err
+}
+
+// Close flushes the writer and restores the attributes that the console
+// had when the writer was created. It does not close the underlying writer.
+func (cw *ansiColorWriter) Close() error {
+	err := cw.Flush()
+	if cw.console != nil && cw.defaultAttr != nil {
+		if cerr := cw.console.SetTextAttribute(convertWinAttr(cw.defaultAttr)); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
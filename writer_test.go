@@ -0,0 +1,1243 @@
+// Copyright 2014 shiena Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ansicolor_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/shiena/ansicolor"
+)
+
+const simDefaultAttr = uint16(0x0007)
+
+func newSimWriter(inner io.Writer, console ansicolor.Console) io.Writer {
+	return ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithConsole(console))
+}
+
+func TestSimWritePlanText(t *testing.T) {
+	inner := bytes.NewBufferString("")
+	w := newSimWriter(inner, ansicolor.NewSimConsole(80, 25, simDefaultAttr))
+	expected := "plain text"
+	fmt.Fprintf(w, expected)
+	actual := inner.String()
+	if actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
+
+func TestSimWriteParseText(t *testing.T) {
+	inner := bytes.NewBufferString("")
+	w := newSimWriter(inner, ansicolor.NewSimConsole(80, 25, simDefaultAttr))
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"\x1b[0mtail text", "tail text"},
+		{"head text\x1b[0m", "head text"},
+		{"both ends \x1b[0m text", "both ends  text"},
+		{"\x1b\x1b\x1b\x1b[0m many esc", "\x1b\x1b\x1b many esc"},
+	}
+	for _, v := range tests {
+		fmt.Fprint(w, v.input)
+		actual := inner.String()
+		inner.Reset()
+		if actual != v.expected {
+			t.Errorf("Get %q, want %q", actual, v.expected)
+		}
+	}
+
+	expectedSplit := "split  text"
+	for _, ch := range "split \x1b[0m text" {
+		fmt.Fprint(w, string(ch))
+	}
+	actualSplit := inner.String()
+	inner.Reset()
+	if actualSplit != expectedSplit {
+		t.Errorf("Get %q, want %q", actualSplit, expectedSplit)
+	}
+}
+
+func TestSimWriteAnsiColorText(t *testing.T) {
+	tests := []struct {
+		text       string
+		initial    uint16
+		attributes uint16
+		ansiColor  string
+	}{
+		{"foreground black  ", 0x0007, 0x0000, "30"},
+		{"foreground red    ", 0x0007, 0x0004, "31"},
+		{"foreground green  ", 0x0007, 0x0002, "32"},
+		{"foreground yellow ", 0x0007, 0x0006, "33"},
+		{"foreground blue   ", 0x0007, 0x0001, "34"},
+		{"foreground magenta", 0x0007, 0x0005, "35"},
+		{"foreground cyan   ", 0x0007, 0x0003, "36"},
+		{"foreground white  ", 0x0007, 0x0007, "37"},
+		{"foreground default", 0x0004, 0x0007, "39"},
+		{"foreground light gray   ", 0x0007, 0x0008, "90"},
+		{"foreground light red    ", 0x0007, 0x000c, "91"},
+		{"foreground light green  ", 0x0007, 0x000a, "92"},
+		{"foreground light yellow ", 0x0007, 0x000e, "93"},
+		{"foreground light blue   ", 0x0007, 0x0009, "94"},
+		{"foreground light magenta", 0x0007, 0x000d, "95"},
+		{"foreground light cyan   ", 0x0007, 0x000b, "96"},
+		{"foreground light white  ", 0x0007, 0x000f, "97"},
+
+		{"background black  ", 0x0077, 0x0007, "40"},
+		{"background red    ", 0x0077, 0x0047, "41"},
+		{"background green  ", 0x0077, 0x0027, "42"},
+		{"background yellow ", 0x0077, 0x0067, "43"},
+		{"background blue   ", 0x0077, 0x0017, "44"},
+		{"background magenta", 0x0077, 0x0057, "45"},
+		{"background cyan   ", 0x0077, 0x0037, "46"},
+		{"background white  ", 0x0077, 0x0077, "47"},
+		{"background default", 0x0077, 0x0007, "49"},
+		{"background light gray   ", 0x0077, 0x0087, "100"},
+		{"background light red    ", 0x0077, 0x00c7, "101"},
+		{"background light green  ", 0x0077, 0x00a7, "102"},
+		{"background light yellow ", 0x0077, 0x00e7, "103"},
+		{"background light blue   ", 0x0077, 0x0097, "104"},
+		{"background light magenta", 0x0077, 0x00d7, "105"},
+		{"background light cyan   ", 0x0077, 0x00b7, "106"},
+		{"background light white  ", 0x0077, 0x00f7, "107"},
+
+		{"all reset", 0x0078, 0x0007, "0"},
+		{"all reset", 0x0078, 0x0007, ""},
+
+		{"bold on", 0x0007, 0x000f, "1"},
+		{"bold off", 0x000f, 0x0007, "21"},
+		{"underscore on", 0x0007, 0x8007, "4"},
+		{"underscore off", 0x8007, 0x0007, "24"},
+		{"blink on", 0x0007, 0x0087, "5"},
+		{"blink off", 0x0087, 0x0007, "25"},
+
+		{"both black,   bold, underline, blink", 0x0007, 0x8088, "30;40;1;4;5"},
+		{"both red,     bold, underline, blink", 0x0007, 0x80cc, "31;41;1;4;5"},
+		{"both green,   bold, underline, blink", 0x0007, 0x80aa, "32;42;1;4;5"},
+		{"both yellow,  bold, underline, blink", 0x0007, 0x80ee, "33;43;1;4;5"},
+		{"both blue,    bold, underline, blink", 0x0007, 0x8099, "34;44;1;4;5"},
+		{"both magenta, bold, underline, blink", 0x0007, 0x80dd, "35;45;1;4;5"},
+		{"both cyan,    bold, underline, blink", 0x0007, 0x80bb, "36;46;1;4;5"},
+		{"both white,   bold, underline, blink", 0x0007, 0x80ff, "37;47;1;4;5"},
+		{"both default, bold, underline, blink", 0x0007, 0x808f, "39;49;1;4;5"},
+	}
+
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		w := newSimWriter(inner, console)
+		console.SetTextAttribute(v.initial)
+		fmt.Fprintf(w, "\x1b[%sm%s", v.ansiColor, v.text)
+
+		if actual := inner.String(); actual != v.text {
+			t.Errorf("Get %q, want %q", actual, v.text)
+		}
+		info, err := console.ScreenBufferInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Attributes != v.attributes {
+			t.Errorf("Text: %q, Get 0x%04x, want 0x%04x", v.text, info.Attributes, v.attributes)
+		}
+	}
+}
+
+func TestSimIgnoreUnknownSequences(t *testing.T) {
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(console))
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"\x1b[=decpath mode", "\x1b[=decpath mode"},
+		{"\x1b[=tailing esc and bracket\x1b[", "\x1b[=tailing esc and bracket"},
+		{"\x1b[?tailing esc\x1b", "\x1b[\x1b[?tailing esc"},
+		{"\x1b[1h;3punended color code invalid\x1b3", "\x1b\x1b[1h;3punended color code invalid\x1b3"},
+	}
+	for _, v := range tests {
+		fmt.Fprint(w, v.input)
+		actual := inner.String()
+		inner.Reset()
+		if actual != v.expected {
+			t.Errorf("Get %q, want %q", actual, v.expected)
+		}
+	}
+}
+
+func TestSimUnknownEscSequences(t *testing.T) {
+	// ESC ( B as tput sgr0 writes, and ESC = for the keypad
+	input := "a\x1b(B\x1b[mb\x1b=c\x1b[?1049hd"
+	tests := []struct {
+		output   bool
+		expected string
+	}{
+		{false, "abcd"},
+		{true, "a\x1b(Bb\x1b=c\x1b[?1049hd"},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		mode := ansicolor.DiscardNonColorEscSeq
+		if v.output {
+			mode = ansicolor.OutputNonColorEscSeq
+		}
+		w := ansicolor.NewModeAnsiColorWriter(inner, mode, ansicolor.WithConsole(console))
+		fmt.Fprint(w, input)
+		if actual := inner.String(); actual != v.expected {
+			t.Errorf("Get %q, want %q", actual, v.expected)
+		}
+	}
+}
+
+func TestSimCancelledSequences(t *testing.T) {
+	tests := []struct {
+		output   bool
+		input    string
+		expected string
+	}{
+		{false, "a\x1b[3\x18b", "a\x18b"},
+		{false, "a\x1b[3\x1ab", "a\x1ab"},
+		{false, "a\x1b]0;x\x18b", "a\x18b"},
+		{false, "a\x1b[3\xe9b", "a\xe9b"},
+		{false, "\x1b\x1b\x1b[0m many esc", "\x1b\x1b many esc"},
+		{true, "a\x1b[3\x18b", "a\x1b[3\x18b"},
+		{true, "a\x1b[3\xe9b", "a\x1b[3\xe9b"},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		mode := ansicolor.DiscardNonColorEscSeq
+		if v.output {
+			mode = ansicolor.OutputNonColorEscSeq
+		}
+		w := ansicolor.NewModeAnsiColorWriter(inner, mode, ansicolor.WithConsole(console))
+		fmt.Fprint(w, v.input)
+		if actual := inner.String(); actual != v.expected {
+			t.Errorf("Input %q: Get %q, want %q", v.input, actual, v.expected)
+		}
+	}
+}
+
+func TestSimOutputModeDividedSequences(t *testing.T) {
+	input := "\x1b[31mred\x1b[?25l\x1b[1;44mbold\x1b[6ndone"
+
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(console))
+	for i := 0; i < len(input); i++ {
+		w.Write([]byte{input[i]})
+	}
+	if actual, expected := inner.String(), "red\x1b[?25lbold\x1b[6ndone"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+	if info, _ := console.ScreenBufferInfo(); info.Attributes != 0x001c {
+		t.Errorf("Get 0x%04x, want 0x%04x", info.Attributes, 0x001c)
+	}
+}
+
+func TestWriteWithoutConsole(t *testing.T) {
+	inner := bytes.NewBufferString("")
+	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithConsole(nil))
+	expected := "\x1b[31mred\x1b[0m"
+	fmt.Fprint(w, expected)
+	if actual := inner.String(); actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
+
+// brokenConsole is a console whose screen buffer cannot be read, like a
+// redirected handle.
+type brokenConsole struct {
+	ansicolor.Console
+}
+
+func (brokenConsole) ScreenBufferInfo() (ansicolor.ScreenBufferInfo, error) {
+	return ansicolor.ScreenBufferInfo{}, errors.New("not a console")
+}
+
+func TestWriteFallback(t *testing.T) {
+	input := "\x1b[31mred\x1b]0;title\x07\x1b[3\x18\n\x1b7\x1b_apc\x1b\\\x1b[0"
+	tests := []struct {
+		console  ansicolor.Console
+		fallback ansicolor.Fallback
+		expected string
+	}{
+		{nil, ansicolor.FallbackPassThrough, input},
+		{nil, ansicolor.FallbackStrip, "red\x18\n"},
+		{brokenConsole{}, ansicolor.FallbackPassThrough, input},
+		{brokenConsole{}, ansicolor.FallbackStrip, "red\x18\n"},
+	}
+	for _, v := range tests {
+		// FallbackStrip strips the sequences even in OutputNonColorEscSeq mode
+		inner := bytes.NewBufferString("")
+		w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq,
+			ansicolor.WithConsole(v.console), ansicolor.WithFallback(v.fallback))
+		fmt.Fprint(w, input)
+		w.(flushCloser).Close()
+
+		if actual := inner.String(); actual != v.expected {
+			t.Errorf("Get %q, want %q", actual, v.expected)
+		}
+	}
+}
+
+func TestSimPrivateAndIntermediateSequences(t *testing.T) {
+	input := "a\x1b[?25lb\x1b[>cc\x1b[2 qd\x1b[!pe\x1b[?1mf"
+
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := newSimWriter(inner, console)
+	fmt.Fprint(w, input)
+	if actual, expected := inner.String(), "abcdef"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+	if info, _ := console.ScreenBufferInfo(); info.Attributes != simDefaultAttr {
+		t.Errorf("Get 0x%04x, want 0x%04x", info.Attributes, simDefaultAttr)
+	}
+
+	inner.Reset()
+	w = ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(console))
+	fmt.Fprint(w, input)
+	if actual := inner.String(); actual != input {
+		t.Errorf("Get %q, want %q", actual, input)
+	}
+}
+
+func TestSimSubParameters(t *testing.T) {
+	tests := []struct {
+		initial    uint16
+		attributes uint16
+		ansiColor  string
+	}{
+		{0x0007, 0x0006, "38:2::255:128:0"},
+		{0x0007, 0x00e7, "48:5:208"},
+		{0x0007, 0x8007, "4:3"},
+		{0x8007, 0x0007, "4:0"},
+		{0x0007, 0x8008, "31;38:2::1:4:5;1;4"},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		w := newSimWriter(inner, console)
+		console.SetTextAttribute(v.initial)
+		fmt.Fprintf(w, "\x1b[%smtext", v.ansiColor)
+
+		if actual := inner.String(); actual != "text" {
+			t.Errorf("Get %q, want %q", actual, "text")
+		}
+		if info, _ := console.ScreenBufferInfo(); info.Attributes != v.attributes {
+			t.Errorf("Param: %q, Get 0x%04x, want 0x%04x", v.ansiColor, info.Attributes, v.attributes)
+		}
+	}
+}
+
+func TestSimOperatingSystemCommand(t *testing.T) {
+	input := "\x1b]0;window title\x07build \x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\ done"
+
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := newSimWriter(inner, console)
+	for i := 0; i < len(input); i++ {
+		w.Write([]byte{input[i]})
+	}
+	if actual, expected := inner.String(), "build link done"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+
+	inner.Reset()
+	w = ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(console))
+	fmt.Fprint(w, input)
+	if actual := inner.String(); actual != input {
+		t.Errorf("Get %q, want %q", actual, input)
+	}
+
+	inner.Reset()
+	var payloads []string
+	handler := func(payload []byte) {
+		payloads = append(payloads, string(payload))
+	}
+	w = ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(console), ansicolor.WithOSCHandler(handler))
+	fmt.Fprint(w, input)
+	if actual, expected := inner.String(), "build link done"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+	expected := []string{"0;window title", "8;;http://example.com", "8;;"}
+	if !reflect.DeepEqual(payloads, expected) {
+		t.Errorf("Get %q, want %q", payloads, expected)
+	}
+}
+
+func TestSimC1Controls(t *testing.T) {
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithConsole(console), ansicolor.WithC1Controls())
+	fmt.Fprint(w, "\x9d0;title\x9c\x9b31mred")
+
+	if actual, expected := inner.String(), "red"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+	if info, _ := console.ScreenBufferInfo(); info.Attributes != 0x0004 {
+		t.Errorf("Get 0x%04x, want 0x%04x", info.Attributes, 0x0004)
+	}
+
+	// Latin-1 text before the controls
+	tests := []struct {
+		input    string
+		expected string
+		attr     uint16
+	}{
+		{"caf\xe9\x9b32mgreen", "caf\xe9green", 0x0002},
+		{"\x9d0;caf\xe9\x9c\x9b33mbuild output", "build output", 0x0006},
+	}
+	for _, v := range tests {
+		inner.Reset()
+		fmt.Fprint(w, v.input)
+		if actual := inner.String(); actual != v.expected {
+			t.Errorf("Input %q: Get %q, want %q", v.input, actual, v.expected)
+		}
+		if info, _ := console.ScreenBufferInfo(); info.Attributes != v.attr {
+			t.Errorf("Input %q: Get 0x%04x, want 0x%04x", v.input, info.Attributes, v.attr)
+		}
+	}
+
+	inner.Reset()
+	w = newSimWriter(inner, console)
+	fmt.Fprint(w, "\x9b32m")
+	if actual, expected := inner.String(), "\x9b32m"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
+
+func TestSimStringSequences(t *testing.T) {
+	input := "a\x1bPq#0;2;0;0;0#0!10~\x1b\\b\x1b_tmux;cmd\x1b\\c\x1b^pm\x1b\\d\x1bXsos\x1b\\e"
+
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := newSimWriter(inner, console)
+	for i := 0; i < len(input); i++ {
+		w.Write([]byte{input[i]})
+	}
+	if actual, expected := inner.String(), "abcde"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+
+	inner.Reset()
+	w = ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(console))
+	fmt.Fprint(w, "a\x1bPq#0;2;0;0;0#0!10~\x1b\\b")
+	if actual, expected := inner.String(), "a\x1bPq#0;2;0;0;0#0!10~\x1b\\b"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+
+	inner.Reset()
+	w = ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(console), ansicolor.WithMaxStringLength(8))
+	fmt.Fprint(w, "a\x1bPq#0;2;0;0;0#0!10~\x1b\\b\x1b_short\x1b\\c")
+	if actual, expected := inner.String(), "ab\x1b_short\x1b\\c"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
+
+var errWriteLimit = errors.New("write limit exceeded")
+
+// limitedWriter writes at most limit bytes and then fails. A writer with
+// short set reports a short write without an error instead.
+type limitedWriter struct {
+	bytes.Buffer
+	limit int
+	short bool
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.limit {
+		w.limit -= len(p)
+		return w.Buffer.Write(p)
+	}
+	n, _ := w.Buffer.Write(p[:w.limit])
+	w.limit = 0
+	if w.short {
+		return n, nil
+	}
+	return n, errWriteLimit
+}
+
+func TestSimWriteCount(t *testing.T) {
+	tests := []struct {
+		input    string
+		output   bool
+		limit    int
+		short    bool
+		n        int
+		err      error
+		expected string
+	}{
+		{"abc\x1b[31mdef", false, 100, false, 11, nil, "abcdef"},
+		{"abc\x1b[3", false, 100, false, 6, nil, "abc"},
+		{"abc\x1b[31mdef", false, 4, false, 9, errWriteLimit, "abcd"},
+		{"abc\x1b[31mdef", false, 3, false, 8, errWriteLimit, "abc"},
+		{"abc\x1b[31mdef", false, 2, false, 2, errWriteLimit, "ab"},
+		{"abc\x1b[31mdef", false, 4, true, 9, io.ErrShortWrite, "abcd"},
+		{"abc\x1b[?25ldef", true, 5, false, 5, errWriteLimit, "abc\x1b["},
+		{"abc\x1b[?25ldef", true, 100, false, 12, nil, "abc\x1b[?25ldef"},
+	}
+	for _, v := range tests {
+		inner := &limitedWriter{limit: v.limit, short: v.short}
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		mode := ansicolor.DiscardNonColorEscSeq
+		if v.output {
+			mode = ansicolor.OutputNonColorEscSeq
+		}
+		w := ansicolor.NewModeAnsiColorWriter(inner, mode, ansicolor.WithConsole(console))
+		n, err := w.Write([]byte(v.input))
+		if n != v.n || err != v.err {
+			t.Errorf("Input %q: Get %d, %v, want %d, %v", v.input, n, err, v.n, v.err)
+		}
+		if actual := inner.String(); actual != v.expected {
+			t.Errorf("Input %q: Get %q, want %q", v.input, actual, v.expected)
+		}
+	}
+}
+
+func TestSimWriteCountAcrossWrites(t *testing.T) {
+	// the interrupted sequence is written before the failure, but it is
+	// not a part of the second input
+	inner := &limitedWriter{limit: 1}
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(console))
+	if n, err := w.Write([]byte("\x1b[")); n != 2 || err != nil {
+		t.Errorf("Get %d, %v, want %d, %v", n, err, 2, nil)
+	}
+	if n, err := w.Write([]byte("\x1b[31m")); n != 0 || err != errWriteLimit {
+		t.Errorf("Get %d, %v, want %d, %v", n, err, 0, errWriteLimit)
+	}
+	if actual, expected := inner.String(), "\x1b"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
+
+// countingWriter counts the calls to Write.
+type countingWriter struct {
+	bytes.Buffer
+	calls int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.calls++
+	return w.Buffer.Write(p)
+}
+
+func TestSimWriteCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		calls    int
+		expected string
+	}{
+		{"line1\nline2\nline3\n\tx\r\n", 1, "line1\nline2\nline3\n\tx\r\n"},
+		{"a\r\nb\x1b[31mc\nd\x1b[0m\n", 3, "a\r\nbc\nd\n"},
+		{"a\x1b[1;2\n3mb", 3, "a\nb"},
+		{"a\x1b[?25l\nb", 2, "a\nb"},
+	}
+	for _, v := range tests {
+		inner := &countingWriter{}
+		w := newSimWriter(inner, ansicolor.NewSimConsole(80, 25, simDefaultAttr))
+		fmt.Fprint(w, v.input)
+		if inner.calls != v.calls {
+			t.Errorf("Input %q: Get %d calls, want %d calls", v.input, inner.calls, v.calls)
+		}
+		if actual := inner.String(); actual != v.expected {
+			t.Errorf("Input %q: Get %q, want %q", v.input, actual, v.expected)
+		}
+	}
+
+	// a line feed at the bottom margin of a scrolling region
+	console := ansicolor.NewSimConsole(10, 4, simDefaultAttr)
+	inner := &countingWriter{}
+	w := newSimWriter(io.MultiWriter(inner, console), console)
+	fmt.Fprint(w, "\x1b[1;3r\x1b[3;1Ha\nb\nc")
+	if inner.calls != 3 {
+		t.Errorf("Get %d calls, want %d calls", inner.calls, 3)
+	}
+	if actual, expected := console.String(), "a\nb\nc\n"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
+
+func TestWriteCountWithoutConsole(t *testing.T) {
+	for _, short := range []bool{false, true} {
+		inner := &limitedWriter{limit: 3, short: short}
+		w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithConsole(nil))
+		n, err := w.Write([]byte("\x1b[0m"))
+		if n != 3 || err == nil {
+			t.Errorf("Get %d, %v, want %d and an error", n, err, 3)
+		}
+	}
+}
+
+func TestSimCopy(t *testing.T) {
+	input := strings.Repeat("\x1b[31mred\x1b[0m \x1b[?25l", 10000)
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := newSimWriter(inner, console)
+
+	n, err := io.Copy(w, iotest.OneByteReader(strings.NewReader(input)))
+	if n != int64(len(input)) || err != nil {
+		t.Errorf("Get %d, %v, want %d, %v", n, err, len(input), nil)
+	}
+	if actual, expected := inner.String(), strings.Repeat("red ", 10000); actual != expected {
+		t.Errorf("Get %d bytes, want %d bytes", len(actual), len(expected))
+	}
+}
+
+type flushCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func TestSimFlush(t *testing.T) {
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := newSimWriter(inner, console).(flushCloser)
+	fmt.Fprint(w, "text\x1b[3")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(w, "1m")
+	if actual, expected := inner.String(), "text1m"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+
+	inner.Reset()
+	w = ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(console)).(flushCloser)
+	fmt.Fprint(w, "text\x1b]0;tit")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := inner.String(), "text\x1b]0;tit"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
+
+func TestSimFlushOverlongSequence(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"csi", "a\x1b[" + strings.Repeat("1", 300)},
+		{"osc", "a\x1b]0;" + strings.Repeat("x", 300)},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithConsole(console), ansicolor.WithSequenceLimits(16, 16), ansicolor.WithMaxStringLength(16)).(flushCloser)
+		fmt.Fprint(w, v.input)
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(w, "hello")
+		if actual, expected := inner.String(), "ahello"; actual != expected {
+			t.Errorf("%s: Get %q, want %q", v.name, actual, expected)
+		}
+	}
+}
+
+func TestSimClose(t *testing.T) {
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, 0x0017)
+	w := newSimWriter(inner, console).(flushCloser)
+	fmt.Fprint(w, "\x1b[31;42mred on green\x1b[1")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := inner.String(), "red on green"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+	if info, _ := console.ScreenBufferInfo(); info.Attributes != 0x0017 {
+		t.Errorf("Get 0x%04x, want 0x%04x", info.Attributes, 0x0017)
+	}
+
+	w = ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithConsole(nil)).(flushCloser)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimSequenceLimits(t *testing.T) {
+	input := "a\x1b[1;2;3;4;5mb\x1b[31mc"
+
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, ansicolor.WithConsole(console), ansicolor.WithSequenceLimits(16, 4))
+	fmt.Fprint(w, input)
+	if actual, expected := inner.String(), "abc"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+	if info, _ := console.ScreenBufferInfo(); info.Attributes != 0x0004 {
+		t.Errorf("Get 0x%04x, want 0x%04x", info.Attributes, 0x0004)
+	}
+
+	inner.Reset()
+	w = ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, ansicolor.WithConsole(console), ansicolor.WithSequenceLimits(16, 4))
+	fmt.Fprint(w, input)
+	if actual, expected := inner.String(), "a\x1b[1;2;3;4;5mbc"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
+
+func TestSimHostileSequence(t *testing.T) {
+	inner := bytes.NewBufferString("")
+	console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	w := newSimWriter(inner, console)
+	w.Write([]byte("\x1b["))
+	for i := 0; i < 1000; i++ {
+		w.Write(bytes.Repeat([]byte(";"), 1000))
+	}
+	w.Write([]byte("mtext"))
+	if actual, expected := inner.String(), "text"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
+
+func TestSim256Colors(t *testing.T) {
+	tests := []struct {
+		initial    uint16
+		attributes uint16
+		ansiColor  string
+	}{
+		{0x0007, 0x0004, "38;5;1"},
+		{0x0007, 0x000c, "38;5;9"},
+		{0x0007, 0x000e, "38;5;208"},
+		{0x0007, 0x000e, "38:5:208"},
+		{0x0007, 0x000c, "38;5;196"},
+		{0x0007, 0x0008, "38;5;244"},
+		{0x0007, 0x0000, "38;5;16"},
+		{0x0007, 0x000f, "38;5;231"},
+		{0x0007, 0x0097, "48;5;21"},
+		{0x0007, 0x0017, "48;5;4"},
+		{0x0007, 0x800e, "38;5;208;4"},
+		{0x0007, 0x0007, "38;5;256"},
+		{0x0007, 0x0007, "38;5"},
+		{0x0007, 0x0047, "38;9;41"},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		w := newSimWriter(inner, console)
+		console.SetTextAttribute(v.initial)
+		fmt.Fprintf(w, "\x1b[%smtext", v.ansiColor)
+
+		if actual := inner.String(); actual != "text" {
+			t.Errorf("Get %q, want %q", actual, "text")
+		}
+		if info, _ := console.ScreenBufferInfo(); info.Attributes != v.attributes {
+			t.Errorf("Param: %q, Get 0x%04x, want 0x%04x", v.ansiColor, info.Attributes, v.attributes)
+		}
+	}
+}
+
+func TestSimTrueColors(t *testing.T) {
+	tests := []struct {
+		metric     ansicolor.ColorMetric
+		attributes uint16
+		ansiColor  string
+	}{
+		{ansicolor.RGBDistance, 0x000c, "38;2;250;10;10"},
+		{ansicolor.RGBDistance, 0x000c, "38:2:250:10:10"},
+		{ansicolor.RGBDistance, 0x000c, "38:2::250:10:10"},
+		{ansicolor.RGBDistance, 0x00c7, "48;2;250;10;10"},
+		{ansicolor.RGBDistance, 0x0000, "38;2;1;4;5"},
+		{ansicolor.RGBDistance, 0x0008, "38;2;1;4;5;1"},
+		{ansicolor.RGBDistance, 0x0007, "38;2;256;0;0"},
+		{ansicolor.RGBDistance, 0x0007, "38;2;255;0"},
+		{ansicolor.RGBDistance, 0x0004, "38;2;;;;31"},
+		{ansicolor.RGBDistance, 0x0001, "38;2;0;0;100"},
+		{ansicolor.CIELABDistance, 0x0001, "38;2;0;0;100"},
+		{ansicolor.RGBDistance, 0x0000, "38;2;64;64;64"},
+		{ansicolor.CIELABDistance, 0x0008, "38;2;64;64;64"},
+		{ansicolor.RGBDistance, 0x0006, "38;2;255;128;0"},
+		{ansicolor.CIELABDistance, 0x000c, "38;2;255;128;0"},
+		{ansicolor.CIELABDistance, 0x000c, "38;5;208"},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq,
+			ansicolor.WithConsole(console), ansicolor.WithColorMetric(v.metric))
+		// the second write is answered from the cache
+		for i := 0; i < 2; i++ {
+			fmt.Fprintf(w, "\x1b[0m\x1b[%smtext", v.ansiColor)
+			if info, _ := console.ScreenBufferInfo(); info.Attributes != v.attributes {
+				t.Errorf("Param: %q, Get 0x%04x, want 0x%04x", v.ansiColor, info.Attributes, v.attributes)
+			}
+		}
+		if actual := inner.String(); actual != "texttext" {
+			t.Errorf("Get %q, want %q", actual, "texttext")
+		}
+	}
+}
+
+func TestSimReverseAndConceal(t *testing.T) {
+	tests := []struct {
+		ansiColors []string
+		attributes uint16
+	}{
+		{[]string{"7"}, 0x0070},
+		{[]string{"7;31"}, 0x0040},
+		{[]string{"7;27"}, 0x0007},
+		{[]string{"7;7;27"}, 0x0007},
+		{[]string{"7", "31", "27"}, 0x0004},
+		{[]string{"91;44;7"}, 0x00c1},
+		{[]string{"8"}, 0x0000},
+		{[]string{"44;8"}, 0x0011},
+		{[]string{"32;8", "28"}, 0x0002},
+		{[]string{"7;8"}, 0x0077},
+		{[]string{"7;8", "0"}, 0x0007},
+		{[]string{"7;4"}, 0x8070},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		w := newSimWriter(inner, console)
+		for _, ansiColor := range v.ansiColors {
+			fmt.Fprintf(w, "\x1b[%smtext", ansiColor)
+		}
+
+		if info, _ := console.ScreenBufferInfo(); info.Attributes != v.attributes {
+			t.Errorf("Params: %q, Get 0x%04x, want 0x%04x", v.ansiColors, info.Attributes, v.attributes)
+		}
+	}
+}
+
+func TestSimAttributeRenderings(t *testing.T) {
+	italicUnderline := ansicolor.WithRendering(ansicolor.Italic, ansicolor.RenderUnderline)
+	tests := []struct {
+		opts       []ansicolor.Option
+		initial    uint16
+		attributes uint16
+		ansiColor  string
+	}{
+		{nil, 0x000f, 0x0007, "2"},
+		{nil, 0x0007, 0x0007, "1;2"},
+		{nil, 0x0007, 0x000f, "1;2;22;1"},
+		{nil, 0x000f, 0x0007, "22"},
+		{nil, 0x0007, 0x0004, "91;2"},
+		{nil, 0x0007, 0x0007, "3"},
+		{[]ansicolor.Option{italicUnderline}, 0x0007, 0x8007, "3"},
+		{[]ansicolor.Option{italicUnderline}, 0x0007, 0x0007, "3;23"},
+		{nil, 0x0007, 0x0007, "20;23"},
+		{nil, 0x0007, 0x0007, "9"},
+		{[]ansicolor.Option{ansicolor.WithRendering(ansicolor.Strikethrough, ansicolor.RenderReverse)}, 0x0007, 0x0070, "9"},
+		{[]ansicolor.Option{ansicolor.WithRendering(ansicolor.Strikethrough, ansicolor.RenderReverse)}, 0x0007, 0x0007, "9;7"},
+		{nil, 0x0007, 0x0407, "53"},
+		{nil, 0x0007, 0x0007, "53;55"},
+		{nil, 0x0007, 0x1c07, "51"},
+		{nil, 0x0007, 0x0007, "52;54"},
+		{nil, 0x0007, 0x0087, "5"},
+		{nil, 0x0007, 0x0007, "6"},
+		{[]ansicolor.Option{ansicolor.WithRendering(ansicolor.RapidBlink, ansicolor.RenderBackgroundIntensity)}, 0x0007, 0x0087, "6"},
+		{[]ansicolor.Option{ansicolor.WithRendering(ansicolor.RapidBlink, ansicolor.RenderBackgroundIntensity)}, 0x0007, 0x0007, "5;6;25"},
+		{[]ansicolor.Option{ansicolor.WithRendering(ansicolor.Blink, ansicolor.RenderNone)}, 0x0007, 0x0007, "5"},
+		{[]ansicolor.Option{ansicolor.WithRendering(ansicolor.Bold, ansicolor.RenderNone)}, 0x0007, 0x0007, "1"},
+		{nil, 0x0007, 0x0007, "58;2;1;4;5"},
+		{nil, 0x0007, 0x0007, "58:5:4;59"},
+		{nil, 0x0007, 0x0007, "10;11;26;50;60;73"},
+		{nil, 0x0007, 0x0007, "1;2;3;5;6;9;51;53;0"},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		opts := append([]ansicolor.Option{ansicolor.WithConsole(console)}, v.opts...)
+		w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq, opts...)
+		console.SetTextAttribute(v.initial)
+		fmt.Fprintf(w, "\x1b[%smtext", v.ansiColor)
+
+		if actual := inner.String(); actual != "text" {
+			t.Errorf("Get %q, want %q", actual, "text")
+		}
+		if info, _ := console.ScreenBufferInfo(); info.Attributes != v.attributes {
+			t.Errorf("Param: %q, Get 0x%04x, want 0x%04x", v.ansiColor, info.Attributes, v.attributes)
+		}
+	}
+}
+
+func TestSimBoldPolicy(t *testing.T) {
+	tests := []struct {
+		policy     ansicolor.BoldPolicy
+		attributes uint16
+		ansiColor  string
+	}{
+		{ansicolor.BoldAsIntensity, 0x000c, "1;31"},
+		{ansicolor.BoldAsIntensity, 0x0008, "1;90"},
+		{ansicolor.BoldAsIntensity, 0x000f, "1"},
+		{ansicolor.BoldIgnored, 0x0004, "1;31"},
+		{ansicolor.BoldIgnored, 0x0008, "1;90"},
+		{ansicolor.BoldIgnored, 0x000f, "97;1"},
+		{ansicolor.BoldBrightensBasicColors, 0x000c, "1;31"},
+		{ansicolor.BoldBrightensBasicColors, 0x000c, "31;1"},
+		{ansicolor.BoldBrightensBasicColors, 0x0008, "1;90"},
+		{ansicolor.BoldBrightensBasicColors, 0x0007, "1"},
+		{ansicolor.BoldBrightensBasicColors, 0x0007, "1;37;39"},
+		{ansicolor.BoldBrightensBasicColors, 0x0001, "1;38;5;4"},
+		{ansicolor.BoldBrightensBasicColors, 0x0004, "1;31;22"},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq,
+			ansicolor.WithConsole(console), ansicolor.WithBoldPolicy(v.policy))
+		fmt.Fprintf(w, "\x1b[%smtext", v.ansiColor)
+
+		if info, _ := console.ScreenBufferInfo(); info.Attributes != v.attributes {
+			t.Errorf("Policy %d, Param: %q, Get 0x%04x, want 0x%04x", v.policy, v.ansiColor, info.Attributes, v.attributes)
+		}
+	}
+}
+
+func TestSimPerWriterDefaults(t *testing.T) {
+	blue := ansicolor.NewSimConsole(80, 25, 0x001e)
+	green := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+	green.SetTextAttribute(0x0002)
+	tests := []struct {
+		console    *ansicolor.SimConsole
+		ansiColors []string
+		attributes uint16
+	}{
+		{blue, []string{"31;41", "39;49"}, 0x001e},
+		{blue, []string{"31;41", "0"}, 0x001e},
+		{blue, []string{"39;41"}, 0x004e},
+		{green, []string{"31;41", "39;49"}, 0x0002},
+		{green, []string{"31;1", "0"}, 0x0002},
+	}
+	for _, v := range tests {
+		w := newSimWriter(bytes.NewBufferString(""), v.console)
+		for _, ansiColor := range v.ansiColors {
+			fmt.Fprintf(w, "\x1b[%smtext", ansiColor)
+		}
+
+		if info, _ := v.console.ScreenBufferInfo(); info.Attributes != v.attributes {
+			t.Errorf("Params: %q, Get 0x%04x, want 0x%04x", v.ansiColors, info.Attributes, v.attributes)
+		}
+	}
+}
+
+func TestSimPalette(t *testing.T) {
+	custom := ansicolor.DefaultPalette
+	custom.Foreground[3] = 0x4
+	custom.DefaultForeground, custom.HasDefaultForeground = 0x1, true
+	custom.DefaultBackground, custom.HasDefaultBackground = 0xf, true
+	literal := ansicolor.Palette{
+		Foreground: [16]uint16{0, 4, 2, 6, 1, 5, 3, 7, 8, 12, 10, 14, 9, 13, 11, 15},
+		Background: [16]uint16{0, 4, 2, 6, 1, 5, 3, 7, 8, 12, 10, 14, 9, 13, 11, 15},
+	}
+	tests := []struct {
+		palette    ansicolor.Palette
+		attributes uint16
+		ansiColor  string
+	}{
+		{ansicolor.DefaultPalette, 0x0006, "33"},
+		{ansicolor.DefaultPalette, 0x000f, "97"},
+		{ansicolor.LightPalette, 0x0000, "97"},
+		{ansicolor.LightPalette, 0x0008, "37"},
+		{ansicolor.LightPalette, 0x00f4, "33;107"},
+		{ansicolor.LightPalette, 0x0004, "93"},
+		{ansicolor.LightPalette, 0x00f4, "38;5;3;48;5;15"},
+		{ansicolor.LightPalette, 0x0077, "47"},
+		{ansicolor.LightPalette, 0x0007, "97;0"},
+		{ansicolor.MonochromePalette, 0x0007, "31;44"},
+		{ansicolor.MonochromePalette, 0x008f, "91;104"},
+		{ansicolor.MonochromePalette, 0x0007, "38;5;4;39"},
+		{ansicolor.MonochromePalette, 0x0087, "38;5;1;48;5;12"},
+		{custom, 0x0004, "33"},
+		{custom, 0x00f1, "39;49"},
+		{custom, 0x00f1, "31;42;0"},
+		{custom, 0x00f9, "0;1"},
+		{custom, 0x0004, "38;5;3"},
+		{literal, 0x0007, "31;44;0"},
+		{literal, 0x0017, "31;44;39"},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.DiscardNonColorEscSeq,
+			ansicolor.WithConsole(console), ansicolor.WithPalette(v.palette))
+		fmt.Fprintf(w, "\x1b[%smtext", v.ansiColor)
+
+		if info, _ := console.ScreenBufferInfo(); info.Attributes != v.attributes {
+			t.Errorf("Param: %q, Get 0x%04x, want 0x%04x", v.ansiColor, info.Attributes, v.attributes)
+		}
+	}
+	if ansicolor.DefaultPalette.Foreground[3] != 0x6 {
+		t.Errorf("DefaultPalette is changed")
+	}
+}
+
+func TestSimStyleStack(t *testing.T) {
+	tests := []struct {
+		opts       []ansicolor.Option
+		input      string
+		attributes uint16
+	}{
+		{nil, "\x1b[31m\x1b[#{\x1b[1;44m\x1b[#}", 0x0004},
+		{nil, "\x1b[31m\x1b[#p\x1b[1;44m\x1b[#q", 0x0004},
+		{nil, "\x1b[31m\x1b[#{\x1b[32m\x1b[#{\x1b[33m\x1b[#}", 0x0002},
+		{nil, "\x1b[31m\x1b[#{\x1b[32m\x1b[#{\x1b[33m\x1b[#}\x1b[#}", 0x0004},
+		{nil, "\x1b[31m\x1b[#}", 0x0004},
+		{nil, "\x1b[31m\x1b[30#{\x1b[1;32;44m\x1b[#}", 0x001c},
+		{nil, "\x1b[1;31m\x1b[1;31#{\x1b[22;32;44m\x1b[#}", 0x000a},
+		{nil, "\x1b[4;7m\x1b[4#{\x1b[24;27m\x1b[#}", 0x8007},
+		{nil, "\x1b[#{\x1b[91m", 0x000c},
+		{[]ansicolor.Option{ansicolor.WithStyleStackLimit(0)}, "\x1b[31m\x1b[#{\x1b[32m\x1b[#}", 0x0002},
+		{[]ansicolor.Option{ansicolor.WithStyleStackLimit(1)}, "\x1b[31m\x1b[#{\x1b[32m\x1b[#{\x1b[33m\x1b[#}\x1b[#}", 0x0002},
+	}
+	for _, v := range tests {
+		inner := bytes.NewBufferString("")
+		console := ansicolor.NewSimConsole(80, 25, simDefaultAttr)
+		opts := append([]ansicolor.Option{ansicolor.WithConsole(console)}, v.opts...)
+		w := ansicolor.NewModeAnsiColorWriter(inner, ansicolor.OutputNonColorEscSeq, opts...)
+		fmt.Fprint(w, v.input+"text")
+
+		if actual := inner.String(); actual != "text" {
+			t.Errorf("Input %q: Get %q, want %q", v.input, actual, "text")
+		}
+		if info, _ := console.ScreenBufferInfo(); info.Attributes != v.attributes {
+			t.Errorf("Input %q: Get 0x%04x, want 0x%04x", v.input, info.Attributes, v.attributes)
+		}
+	}
+}
+
+func TestSimCursorMovement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected ansicolor.Coord
+	}{
+		{"\x1b[3;5H", ansicolor.Coord{X: 4, Y: 2}},
+		{"\x1b[3;5f", ansicolor.Coord{X: 4, Y: 2}},
+		{"\x1b[3;5H\x1b[H", ansicolor.Coord{X: 0, Y: 0}},
+		{"\x1b[;5H", ansicolor.Coord{X: 4, Y: 0}},
+		{"\x1b[0;0H", ansicolor.Coord{X: 0, Y: 0}},
+		{"\x1b[99;99H", ansicolor.Coord{X: 9, Y: 4}},
+		{"\x1b[3;5H\x1b[A", ansicolor.Coord{X: 4, Y: 1}},
+		{"\x1b[3;5H\x1b[2B", ansicolor.Coord{X: 4, Y: 4}},
+		{"\x1b[3;5H\x1b[3C", ansicolor.Coord{X: 7, Y: 2}},
+		{"\x1b[3;5H\x1b[0D", ansicolor.Coord{X: 3, Y: 2}},
+		{"\x1b[3;5H\x1b[E", ansicolor.Coord{X: 0, Y: 3}},
+		{"\x1b[3;5H\x1b[2F", ansicolor.Coord{X: 0, Y: 0}},
+		{"\x1b[3;5H\x1b[8G", ansicolor.Coord{X: 7, Y: 2}},
+		{"\x1b[3;5H\x1b[4d", ansicolor.Coord{X: 4, Y: 3}},
+		{"\x1b[99A\x1b[99D", ansicolor.Coord{X: 0, Y: 0}},
+		{"\x1b[99B\x1b[99C", ansicolor.Coord{X: 9, Y: 4}},
+		{"\x1b[65535B\x1b[65535C", ansicolor.Coord{X: 9, Y: 4}},
+		{"abc\x1b[2Gx", ansicolor.Coord{X: 2, Y: 0}},
+	}
+	for _, v := range tests {
+		console := ansicolor.NewSimConsole(10, 5, simDefaultAttr)
+		w := newSimWriter(console, console)
+		fmt.Fprint(w, v.input)
+
+		if info, _ := console.ScreenBufferInfo(); info.CursorPosition != v.expected {
+			t.Errorf("Input %q: Get %+v, want %+v", v.input, info.CursorPosition, v.expected)
+		}
+	}
+
+	// a progress bar redrawn in place
+	console := ansicolor.NewSimConsole(20, 3, simDefaultAttr)
+	w := newSimWriter(console, console)
+	fmt.Fprint(w, "title\n[    ] 0%\n")
+	for _, p := range []string{"[#   ] 25%", "[##  ] 50%", "[####] 100%"} {
+		fmt.Fprintf(w, "\x1b[F\x1b[G%s\n", p)
+	}
+	if actual, expected := console.String(), "title\n[####] 100%\n"; actual != expected {
+		t.Errorf("Get %q, want %q", actual, expected)
+	}
+}
+
+func TestSimErase(t *testing.T) {
+	const text = "\x1b[1Habcde\x1b[2Habcde\x1b[3Habcde\x1b[4Habcde\x1b[2;3H"
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"\x1b[J", "abcde\nab\n\n"},
+		{"\x1b[0J", "abcde\nab\n\n"},
+		{"\x1b[1J", "\n   de\nabcde\nabcde"},
+		{"\x1b[2J", "\n\n\n"},
+		{"\x1b[K", "abcde\nab\nabcde\nabcde"},
+		{"\x1b[1K", "abcde\n   de\nabcde\nabcde"},
+		{"\x1b[2K", "abcde\n\nabcde\nabcde"},
+		{"\x1b[5J\x1b[5K", "abcde\nabcde\nabcde\nabcde"},
+		{"\x1b[2Kxy", "abcde\n  xy\nabcde\nabcde"},
+	}
+	for _, v := range tests {
+		console := ansicolor.NewSimConsole(6, 4, simDefaultAttr)
+		w := newSimWriter(console, console)
+		fmt.Fprint(w, text+v.input)
+
+		if actual := console.String(); actual != v.expected {
+			t.Errorf("Input %q: Get %q, want %q", v.input, actual, v.expected)
+		}
+		if info, _ := console.ScreenBufferInfo(); info.CursorPosition.Y != 1 {
+			t.Errorf("Input %q: Get %+v, want row 1", v.input, info.CursorPosition)
+		}
+	}
+
+	// the erased cells take the colors but not the underscore
+	console := ansicolor.NewSimConsole(6, 4, simDefaultAttr)
+	w := newSimWriter(console, console)
+	fmt.Fprint(w, "abc\x1b[4;33;44m\x1b[1K\x1b[2B\x1b[2K")
+	for _, pos := range []struct{ x, y int }{{0, 0}, {3, 0}, {5, 2}} {
+		if actual := console.Cell(pos.x, pos.y).Attributes; actual != 0x0016 {
+			t.Errorf("Cell %+v: Get 0x%04x, want 0x%04x", pos, actual, 0x0016)
+		}
+	}
+	if actual := console.Cell(4, 0).Attributes; actual != simDefaultAttr {
+		t.Errorf("Get 0x%04x, want 0x%04x", actual, simDefaultAttr)
+	}
+
+	// ED 3 erases the scrollback above the window only
+	for _, v := range []struct {
+		input    string
+		expected string
+	}{
+		{"\x1b[3J", "\n\nc\nd\ne\nf"},
+		{"\x1b[2J", "a\nb\n\n\n\n"},
+		{"\x1b[H\x1b[2J\x1b[3J", "\n\n\n\n\n"},
+	} {
+		console := ansicolor.NewSimConsole(6, 6, simDefaultAttr)
+		w := newSimWriter(console, console)
+		fmt.Fprint(w, "a\nb\nc\nd\ne\nf")
+		console.SetWindow(ansicolor.SmallRect{Left: 0, Top: 2, Right: 5, Bottom: 5})
+		fmt.Fprint(w, v.input)
+
+		if actual := console.String(); actual != v.expected {
+			t.Errorf("Input %q: Get %q, want %q", v.input, actual, v.expected)
+		}
+	}
+}
+
+func TestSimSaveCursor(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		cell     ansicolor.Coord
+		attr     uint16
+	}{
+		// a footer drawn from the status line code
+		{"line1\x1b7\x1b[5;1H\x1b[44mfooter\x1b8 more", "line1 more\n\n\n\nfooter", ansicolor.Coord{X: 5, Y: 0}, 0x0007},
+		{"line1\x1b[s\x1b[5;1H\x1b[44mfooter\x1b[u more", "line1 more\n\n\n\nfooter", ansicolor.Coord{X: 0, Y: 4}, 0x0017},
+		{"\x1b[31m\x1b7\x1b[1;32mx\x1b8y", "y\n\n\n\n", ansicolor.Coord{X: 0, Y: 0}, 0x0004},
+		{"\x1b[2;2H\x1b[4;31m\x1b[s\x1b[0mab\x1b[ucd", "\n cd\n\n\n", ansicolor.Coord{X: 2, Y: 1}, 0x8004},
+		// both forms share the saved cursor
+		{"\x1b[2;2H\x1b7\x1b[4;4H\x1b[s\x1b[H\x1b8a", "\n\n\n   a\n", ansicolor.Coord{X: 3, Y: 3}, 0x0007},
+		// without a saved cursor
+		{"\x1b[3;3H\x1b[31m\x1b8z", "z\n\n\n\n", ansicolor.Coord{X: 0, Y: 0}, 0x0007},
+		// with parameters
+		{"\x1b[2;2H\x1b[1s\x1b[3;3H\x1b[1uq", "\n\n  q\n\n", ansicolor.Coord{X: 2, Y: 2}, 0x0007},
+	}
+	for _, v := range tests {
+		console := ansicolor.NewSimConsole(10, 5, simDefaultAttr)
+		w := newSimWriter(console, console)
+		fmt.Fprint(w, v.input)
+
+		if actual := console.String(); actual != v.expected {
+			t.Errorf("Input %q: Get %q, want %q", v.input, actual, v.expected)
+		}
+		if actual := console.Cell(int(v.cell.X), int(v.cell.Y)).Attributes; actual != v.attr {
+			t.Errorf("Input %q: Get 0x%04x, want 0x%04x", v.input, actual, v.attr)
+		}
+	}
+}
+
+func TestSimScrollRegion(t *testing.T) {
+	const text = "1\n2\n3\n4\n5\n6"
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{text + "\x1b[2S", "3\n4\n5\n6\n\n"},
+		{text + "\x1b[T", "\n1\n2\n3\n4\n5"},
+		{text + "\x1b[2;4r\x1b[S", "1\n3\n4\n\n5\n6"},
+		{text + "\x1b[2;4r\x1b[0S", "1\n3\n4\n\n5\n6"},
+		{text + "\x1b[2;4r\x1b[2T", "1\n\n\n2\n5\n6"},
+		{text + "\x1b[2;4r\x1b[99S", "1\n\n\n\n5\n6"},
+		{text + "\x1b[2;4r\x1b[65535T", "1\n\n\n\n5\n6"},
+		{text + "\x1b[2;4r\x1b[4;1H\nx", "1\n3\n4\nx\n5\n6"},
+		{text + "\x1b[2;4r\x1b[3;1H\nx", "1\n2\n3\nx\n5\n6"},
+		{text + "\x1b[2;4r\x1b[r\x1b[4;1H\nx", "1\n2\n3\n4\nx\n6"},
+		{text + "\x1b[4;2r\x1b[4;1H\nx", "1\n2\n3\n4\nx\n6"},
+		{text + "\x1b[;4r\x1b[4;1H\nx", "2\n3\n4\nx\n5\n6"},
+		{text + "\x1b[5;99r\x1b[S", "1\n2\n3\n4\n6\n"},
+		// a dashboard with a pinned header and footer
+		{"\x1b[2;5r\x1b[1;1Hheader\x1b[6;1Hfooter\x1b[2;1Ha\nb\nc\nd\ne\nf", "header\nc\nd\ne\nf\nfooter"},
+	}
+	for _, v := range tests {
+		console := ansicolor.NewSimConsole(10, 6, simDefaultAttr)
+		w := newSimWriter(console, console)
+		fmt.Fprint(w, v.input)
+
+		if actual := console.String(); actual != v.expected {
+			t.Errorf("Input %q: Get %q, want %q", v.input, actual, v.expected)
+		}
+	}
+
+	// the header and the footer stay put while the cursor moves or the
+	// text wraps in the region
+	const frame = "\x1b[1;1Hhead\x1b[6;1Hfoot\x1b[2;5r"
+	for _, v := range []struct {
+		input    string
+		expected string
+		cursor   ansicolor.Coord
+	}{
+		{"\x1b[5;1Hab\x1b[B\x1b[Bx", "head\n\n\n\nabx\nfoot", ansicolor.Coord{X: 3, Y: 4}},
+		{"\x1b[5;1Hab\x1b[9E", "head\n\n\n\nab\nfoot", ansicolor.Coord{X: 0, Y: 4}},
+		{"\x1b[3;1H\x1b[9Ay", "head\ny\n\n\n\nfoot", ansicolor.Coord{X: 1, Y: 1}},
+		{"\x1b[3;1H\x1b[9Fy", "head\ny\n\n\n\nfoot", ansicolor.Coord{X: 1, Y: 1}},
+		{"\x1b[H\x1b[9B", "head\n\n\n\n\nfoot", ansicolor.Coord{X: 0, Y: 4}},
+		{"\x1b[6;1H\x1b[9B", "head\n\n\n\n\nfoot", ansicolor.Coord{X: 0, Y: 5}},
+		{"\x1b[5;1H0123456789abcde", "head\n\n\n0123456789\nabcde\nfoot", ansicolor.Coord{X: 5, Y: 4}},
+		{"\x1b[5;1H0123456789", "head\n\n\n0123456789\n\nfoot", ansicolor.Coord{X: 0, Y: 4}},
+		{"\x1b[4;1H0123456789abcdefghijklmno", "head\n\n0123456789\nabcdefghij\nklmno\nfoot", ansicolor.Coord{X: 5, Y: 4}},
+		{"\x1b[5;5Hé0123456789", "head\n\n\n    é01234\n56789\nfoot", ansicolor.Coord{X: 5, Y: 4}},
+	} {
+		console := ansicolor.NewSimConsole(10, 6, simDefaultAttr)
+		w := newSimWriter(console, console)
+		fmt.Fprint(w, frame+v.input)
+
+		if actual := console.String(); actual != v.expected {
+			t.Errorf("Input %q: Get %q, want %q", v.input, actual, v.expected)
+		}
+		if info, _ := console.ScreenBufferInfo(); info.CursorPosition != v.cursor {
+			t.Errorf("Input %q: Get %+v, want %+v", v.input, info.CursorPosition, v.cursor)
+		}
+	}
+
+	// DECSTBM moves the cursor home, and SU and SD do not move it
+	console := ansicolor.NewSimConsole(10, 6, simDefaultAttr)
+	w := newSimWriter(console, console)
+	for _, v := range []struct {
+		input    string
+		expected ansicolor.Coord
+	}{
+		{"\x1b[3;3H\x1b[2;4r", ansicolor.Coord{X: 0, Y: 0}},
+		{"\x1b[3;3H\x1b[S\x1b[2T", ansicolor.Coord{X: 2, Y: 2}},
+		{"\x1b[4;3H\n", ansicolor.Coord{X: 0, Y: 3}},
+	} {
+		fmt.Fprint(w, v.input)
+		if info, _ := console.ScreenBufferInfo(); info.CursorPosition != v.expected {
+			t.Errorf("Input %q: Get %+v, want %+v", v.input, info.CursorPosition, v.expected)
+		}
+	}
+
+	// the rows left behind take the colors but not the underscore
+	fmt.Fprint(w, "\x1b[r\x1b[4;44m\x1b[S")
+	if actual := console.Cell(0, 5).Attributes; actual != 0x0017 {
+		t.Errorf("Get 0x%04x, want 0x%04x", actual, 0x0017)
+	}
+}